@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/pmwals09/git-pretty-log/prettylog"
+)
+
+const completionUsage = "usage: git-pretty-log completion bash|zsh|fish"
+
+// refFlags, dirFlags and fileFlags are the flags whose values are completed
+// as refs, directories and paths. Other flags that take a value get no
+// completion for it.
+var (
+	refFlags  = []string{"base", "b", "snapshot"}
+	dirFlags  = []string{"repo-path", "r"}
+	fileFlags = []string{"exclude", "e", "include", "path", "keyring"}
+)
+
+// printCompletion handles the hidden completion subcommand. With a shell name
+// it prints that shell's completion script; `completion refs [path]` lists
+// the refs of the repository at path for the scripts to offer as bases.
+func printCompletion(out io.Writer, args []string) error {
+	if len(args) == 0 {
+		return errors.New(completionUsage)
+	}
+	if args[0] == "refs" {
+		repoPath := "."
+		if len(args) > 1 && args[1] != "" {
+			repoPath = args[1]
+		}
+		return printRefNames(out, repoPath)
+	}
+
+	fs := flag.NewFlagSet("git-pretty-log", flag.ContinueOnError)
+	defineFlags(fs, &cliArgs{})
+	var flags []*flag.Flag
+	fs.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, f)
+	})
+	switch args[0] {
+	case "bash":
+		fmt.Fprintf(out, bashCompletion, strings.Join(flagNames(flags), " "), strings.Join(dashed(refFlags), "|"), strings.Join(dashed(dirFlags), "|"), strings.Join(dashed(fileFlags), "|"), strings.Join(dashed(valueFlagNames(flags)), "|"))
+	case "zsh":
+		fmt.Fprint(out, zshCompletionHeader)
+		for _, f := range flags {
+			fmt.Fprintf(out, "  '%s[%s]%s' \\\n", dashed([]string{f.Name})[0], zshEscape(f.Usage), zshValueSpec(f))
+		}
+		fmt.Fprint(out, "  && return 0\n")
+	case "fish":
+		fmt.Fprint(out, fishCompletionHeader)
+		for _, f := range flags {
+			fmt.Fprintf(out, "complete -c git-pretty-log %s -d '%s'%s\n", fishOption(f), fishEscape(f.Usage), fishValueSpec(f))
+		}
+	default:
+		return fmt.Errorf("unsupported shell %s; %s", args[0], completionUsage)
+	}
+	return nil
+}
+
+// printRefNames prints the short names of the branches, remote branches and
+// tags of the repository at repoPath, one per line.
+func printRefNames(out io.Writer, repoPath string) error {
+	repo, err := prettylog.OpenRepository(repoPath)
+	if err != nil {
+		return err
+	}
+	refs, err := repo.References()
+	if err != nil {
+		return err
+	}
+	var names []string
+	err = refs.ForEach(func(r *plumbing.Reference) error {
+		if r.Name().IsBranch() || r.Name().IsRemote() || r.Name().IsTag() {
+			names = append(names, r.Name().Short())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slices.Sort(names)
+	for _, name := range slices.Compact(names) {
+		fmt.Fprintln(out, name)
+	}
+	return nil
+}
+
+// takesValue reports whether f needs a value, i.e. isn't a boolean switch.
+func takesValue(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return !ok || !b.IsBoolFlag()
+}
+
+// dashed prefixes flag names the way they're shown in help: one dash for
+// single letters and two for long names, though flag accepts either.
+func dashed(names []string) []string {
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		if len(name) == 1 {
+			out = append(out, "-"+name)
+		} else {
+			out = append(out, "--"+name)
+		}
+	}
+	return out
+}
+
+func flagNames(flags []*flag.Flag) []string {
+	names := make([]string, 0, len(flags))
+	for _, f := range flags {
+		names = append(names, f.Name)
+	}
+	return dashed(names)
+}
+
+func valueFlagNames(flags []*flag.Flag) []string {
+	var names []string
+	for _, f := range flags {
+		if takesValue(f) {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+const bashCompletion = `# bash completion for git-pretty-log
+_git_pretty_log_complete() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+    local prev="${COMP_WORDS[COMP_CWORD-1]}"
+    local repo=. i
+    COMPREPLY=()
+    for ((i = 1; i < COMP_CWORD - 1; i++)); do
+        case "${COMP_WORDS[i]}" in
+            -r|--r|-repo-path|--repo-path) repo="${COMP_WORDS[i+1]}" ;;
+        esac
+    done
+    # flag accepts long names with a single dash too
+    [[ "$prev" == -[!-]?* ]] && prev="-$prev"
+    case "$prev" in
+        %[2]s)
+            COMPREPLY=($(compgen -W "$(git-pretty-log completion refs "$repo" 2>/dev/null)" -- "$cur"))
+            return ;;
+        %[3]s)
+            COMPREPLY=($(compgen -d -- "$cur"))
+            return ;;
+        %[4]s)
+            COMPREPLY=($(compgen -f -- "$cur"))
+            return ;;
+        %[5]s)
+            return ;;
+    esac
+    if [[ "$cur" == -* ]]; then
+        COMPREPLY=($(compgen -W "%[1]s" -- "$cur"))
+    fi
+}
+complete -o default -F _git_pretty_log_complete git-pretty-log
+`
+
+const zshCompletionHeader = `#compdef git-pretty-log
+# zsh completion for git-pretty-log
+
+_git_pretty_log_refs() {
+  local repo=${opt_args[-r]:-${opt_args[--repo-path]:-.}}
+  local -a refs
+  refs=(${(f)"$(git-pretty-log completion refs $repo 2>/dev/null)"})
+  compadd -a refs
+}
+
+_arguments -s \
+`
+
+// zshValueSpec is the _arguments action completing f's value, if it takes
+// one.
+func zshValueSpec(f *flag.Flag) string {
+	switch {
+	case !takesValue(f):
+		return ""
+	case slices.Contains(refFlags, f.Name):
+		return ":ref:_git_pretty_log_refs"
+	case slices.Contains(dirFlags, f.Name):
+		return ":directory:_directories"
+	case slices.Contains(fileFlags, f.Name):
+		return ":path:_files"
+	}
+	return ":value: "
+}
+
+func zshEscape(s string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+}
+
+const fishCompletionHeader = `# fish completion for git-pretty-log
+function __git_pretty_log_refs
+    set -l repo .
+    set -l tokens (commandline -opc)
+    for i in (seq (count $tokens))
+        if contains -- $tokens[$i] -r --r -repo-path --repo-path
+            set repo $tokens[(math $i + 1)]
+        end
+    end
+    git-pretty-log completion refs $repo 2>/dev/null
+end
+
+complete -c git-pretty-log -f
+`
+
+// fishOption names f for fish, which treats one-letter and long options
+// differently.
+func fishOption(f *flag.Flag) string {
+	if len(f.Name) == 1 {
+		return "-s " + f.Name
+	}
+	return "-l " + f.Name
+}
+
+// fishValueSpec is the part of f's complete command describing its value, if
+// it takes one.
+func fishValueSpec(f *flag.Flag) string {
+	switch {
+	case !takesValue(f):
+		return ""
+	case slices.Contains(refFlags, f.Name):
+		return " -x -a '(__git_pretty_log_refs)'"
+	case slices.Contains(dirFlags, f.Name):
+		return " -x -a '(__fish_complete_directories)'"
+	case slices.Contains(fileFlags, f.Name):
+		return " -r -F"
+	}
+	return " -x"
+}
+
+func fishEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s)
+}
@@ -4,334 +4,296 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"regexp"
 	"strings"
 
 	"github.com/fatih/color"
 	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/storer"
-	"github.com/jedib0t/go-pretty/v6/table"
-	"github.com/maniartech/gotime"
+	"github.com/pmwals09/git-pretty-log/prettylog"
 )
 
-type Args struct {
-	baseName      string
-	numberCommits int
-	repoPath      string
-	exclude       stringlist
+// exitUsage is the exit code for command lines the flag set couldn't parse.
+const exitUsage = 2
+
+// cliArgs are the command line flags: the options of the log, plus those
+// only the command has.
+type cliArgs struct {
+	opts      prettylog.Options
+	repoPaths []string
+	noPager   bool
+	version   bool
 }
 
-func (a Args) Parse() (*ParsedArgs, error) {
-	pa := ParsedArgs{numberCommits: a.numberCommits, repoPath: a.repoPath}
-	pa.exclude = make([]string, 0)
-	for _, pathspec := range a.exclude {
-		if pathspec != "" {
-			pa.exclude = append(pa.exclude, pathspec)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "completion" {
+		if err := printCompletion(os.Stdout, os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(prettylog.ExitError)
 		}
+		return
 	}
-
-	repo, err := git.PlainOpen(a.repoPath)
-	if err != nil {
-		return nil, err
-	}
-	pa.repo = repo
-
-	// check if the provided reference is valid
-	var baseCommit *object.Commit
-	if a.baseName == "" {
-		r, err := getBaseBranch(repo)
-		if err != nil {
-			return nil, fmt.Errorf("error getting repo base branch: %w", err)
-		}
-		commit, err := repo.CommitObject(r.Hash())
-		if err != nil {
-			return nil, fmt.Errorf("error getting base branch commit: %w", err)
-		}
-		baseCommit = commit
-	} else {
-		hash, err := repo.ResolveRevision(plumbing.Revision(a.baseName))
-		if err != nil {
-			return nil, fmt.Errorf("the provided base %s is invalid: %w", a.baseName, err)
-		}
-		commit, err := repo.CommitObject(*hash)
-		if err != nil {
-			return nil, fmt.Errorf("error getting provided base %s commit: %w", a.baseName, err)
-		}
-		baseCommit = commit
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		printVersion(os.Stdout)
+		return
 	}
 
-	pa.baseCommit = baseCommit
-	return &pa, nil
-}
-
-type ParsedArgs struct {
-	baseCommit    *object.Commit
-	numberCommits int
-	repo          *git.Repository
-	repoPath      string
-	exclude       []string
-}
-
-type stringlist []string
-
-func (s *stringlist) String() string {
-	return strings.Join(*s, ", ")
-}
-func (s *stringlist) Set(value string) error {
-	*s = append(*s, value)
-	return nil
-}
-
-func main() {
-	// make sure we're in some repository
-	args, err := parseArgs()
-	if err != nil {
+	repoPaths, paging, err := parseRepoPaths(os.Args[0], os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if errors.Is(err, errVersion) {
+		// before any repository is opened, so it works outside of one
+		printVersion(os.Stdout)
+		return
+	} else if errors.Is(err, errUsage) {
+		os.Exit(exitUsage)
+	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "error parsing args: %s\n", err.Error())
-		os.Exit(1)
+		os.Exit(prettylog.ExitError)
 	}
-	repo := args.repo
-
-	// Map local branch hashes to branch name
-	refHashToName, err := makeHashToNameMap(repo)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error mapping ref hashes to names: %s\n", err.Error())
-		os.Exit(1)
+	if len(repoPaths) > 1 {
+		// the repositories may not agree on core.pager, so only the
+		// environment picks the pager
+		os.Exit(withPager(paging, nil, func(out io.Writer) int {
+			return runRepos(os.Args[0], os.Args[1:], repoPaths, out, os.Stderr)
+		}))
 	}
 
-	reachable, err := isBaseReachableFromHead(repo, args)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error determining reachability of base branch: %s\n", err.Error())
+	// make sure we're in some repository
+	pa, _, err := parseArgs(os.Args[0], os.Args[1:], "", os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if errors.Is(err, errUsage) {
+		os.Exit(exitUsage)
+	} else if errors.Is(err, prettylog.ErrNoCommits) {
+		// an empty log rather than a failure, so scripts and prompts run
+		// right after git init aren't tripped up, though the exit code
+		// still says there was nothing to show
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(prettylog.ExitNoCommits)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing args: %s\n", err.Error())
+		os.Exit(prettylog.ExitError)
 	}
 
-	tw := getTableWriter()
-
-	// start walking back n commits
-	log, err := repo.Log(&git.LogOptions{})
-	count := args.numberCommits
-	log.ForEach(func(commit *object.Commit) error {
-		if commit.Hash.String() == args.baseCommit.Hash.String() {
-			reachable = false
-		}
-		if count == 0 {
-			return storer.ErrStop
-		}
-		count--
-
-		// if commit contains master, produce a diff
-		if reachable {
-			printCommitWithDiff(commit, args.baseCommit, &tw, refHashToName, args)
-		} else {
-			printCommit(commit, &tw, refHashToName)
+	os.Exit(withPager(paging, pa.Repository(), func(out io.Writer) int {
+		code, err := pa.Run(out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
 		}
-		return nil
-	})
-
-	tw.Render()
+		return code
+	}))
 }
 
-var validModes = []string{"base", "branch", "commit"}
-
-
-func parseArgs() (*ParsedArgs, error) {
-	args := Args{}
-
-	wd, err := os.Getwd()
-	if err != nil {
-		return nil, err
-	}
+// defaultPager is the pager used when none is configured.
+const defaultPager = "less"
 
-	var longRepo string
-	flag.StringVar(&longRepo, "repo-path", wd, "The path of the git repository")
-	flag.StringVar(&args.repoPath, "r", wd, "The path of the git repository")
-
-	var longBase string
-	flag.StringVar(&longBase, "base", "", "The commit against which to compare")
-	flag.StringVar(&args.baseName, "b", "", "The commit against which to compare")
-
-	var longNumberCommits int
-	flag.IntVar(&longNumberCommits, "num-commits", 30, "The number of commits to display. Note that a large number will degrade performance")
-	flag.IntVar(&args.numberCommits, "n", 30, "The number of commits to display. Note that a large number will degrade performance")
-
-	var longExclude stringlist
-	flag.Var(&longExclude, "exclude", "a valid [pathspec](https://git-scm.com/docs/gitglossary#Documentation/gitglossary.txt-aiddefpathspecapathspec) to exclude from diffing calculations; can be repeated")
-	flag.Var(&args.exclude, "e", "a valid [pathspec](https://git-scm.com/docs/gitglossary#Documentation/gitglossary.txt-aiddefpathspecapathspec) to exclude from diffing calculations; can be repeated")
-
-	flag.Parse()
+// pagerEnv are the environment variables set for the pager, as git sets them,
+// unless they're set already. For less, F quits straight away when the output
+// fits on one screen, R passes colors through and X leaves the output on
+// screen after quitting; lv needs -c for colors.
+var pagerEnv = []struct{ key, value string }{
+	{"LESS", "FRX"},
+	{"LV", "-c"},
+}
 
-	// Prefer the long version if both are provided
-	if longBase != "" {
-		args.baseName = longBase
+// pagerCommand returns the pager to page output through, chosen as git
+// chooses it: $GIT_PAGER, then core.pager from the config of repo unless it
+// is nil, then $PAGER, then defaultPager. It returns "" when the pager is
+// set to cat or to nothing, which turns paging off.
+func pagerCommand(repo *git.Repository) string {
+	command, ok := os.LookupEnv("GIT_PAGER")
+	if !ok && repo != nil {
+		if configured, set, err := prettylog.LookupGitConfig(repo, "core", "pager"); err == nil && set {
+			command, ok = configured, true
+		}
 	}
-	if longRepo != wd {
-		args.repoPath = longRepo
+	if !ok {
+		command, ok = os.LookupEnv("PAGER")
 	}
-	if longNumberCommits != 0 {
-		args.numberCommits = longNumberCommits
+	if !ok {
+		command = defaultPager
 	}
-	if len(longExclude) > 0 {
-		for _, pathspec := range longExclude {
-			args.exclude = append(args.exclude, pathspec)
-		}
+	if command = strings.TrimSpace(command); command == "cat" {
+		return ""
 	}
-
-	return args.Parse()
+	return command
 }
 
-func getBaseBranch(repo *git.Repository) (*plumbing.Reference, error) {
-	VALID_BASE_BRANCH_NAMES := []string{"refs/heads/main", "refs/heads/master"}
-
-	for _, branchName := range VALID_BASE_BRANCH_NAMES {
-		ref, err := repo.Reference(plumbing.ReferenceName(branchName), true)
-		if err != nil {
-			continue
-		} else {
-			return ref, nil
+// pagerEnviron is the environment the pager runs in: ours, plus whatever of
+// pagerEnv isn't set in it.
+func pagerEnviron() []string {
+	env := os.Environ()
+	for _, e := range pagerEnv {
+		if _, ok := os.LookupEnv(e.key); !ok {
+			env = append(env, e.key+"="+e.value)
 		}
 	}
-
-	return nil, errors.New("unable to find base branch among \"main\" or \"master\"")
+	return env
 }
 
-func makeHashToNameMap(repo *git.Repository) (map[string][]string, error) {
-	refs, err := repo.References()
-	if err != nil {
-		return nil, err
+// withPager calls run with a writer to the pager, when paging, and returns
+// its exit code once the pager has been quit. The output goes straight to
+// stdout when not paging, or when the pager can't be started.
+func withPager(paging bool, repo *git.Repository, run func(out io.Writer) int) int {
+	command := ""
+	if paging {
+		command = pagerCommand(repo)
 	}
-	refHashToName := make(map[string][]string)
-	refs.ForEach(func(r *plumbing.Reference) error {
-		refHash := r.Hash().String()
-		if _, ok := refHashToName[refHash]; ok {
-			refHashToName[refHash] = append(refHashToName[refHash], r.Name().Short())
-		} else {
-			refHashToName[refHash] = []string{r.Name().Short()}
-		}
-		return nil
-	})
-	return refHashToName, nil
-}
-
-func isBaseReachableFromHead(repo *git.Repository, args *ParsedArgs) (bool, error) {
-	head, err := repo.Head()
-	if err != nil {
-		return false, err
-	}
-	headCommit, err := repo.CommitObject(head.Hash())
-	if err != nil {
-		return false, err
+	if command == "" {
+		return run(os.Stdout)
 	}
-	mbCommits, err := headCommit.MergeBase(args.baseCommit)
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = pagerEnviron()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
 	if err != nil {
-		return false, err
+		return run(os.Stdout)
 	}
-	reachable := true
-	if len(mbCommits) == 0 {
-		reachable = false
+	if err := cmd.Start(); err != nil {
+		return run(os.Stdout)
 	}
-	return reachable, nil
+	code := run(stdin)
+	stdin.Close()
+	cmd.Wait()
+	return code
 }
 
-func getTableWriter() table.Writer {
-	t := table.NewWriter()
-	t.SetOutputMirror(os.Stdout)
-	t.Style().Options.DrawBorder = false
-	t.Style().Options.SeparateColumns = false
-	t.Style().Options.SeparateFooter = false
-	t.Style().Options.SeparateHeader = false
-	t.Style().Options.SeparateRows = false
-	return t
-}
-
-func printCommit(commit *object.Commit, tw *table.Writer, refHashToName map[string][]string) {
-	hash := prettyHash(commit)
-	relTime := prettyRelativeTime(commit)
-	author := prettyAuthor(commit)
-	diff := ""
-	message := prettyMessage(commit, refHashToName)
-	(*tw).AppendRow(table.Row{hash, relTime, author, diff, message})
-}
-
-func printCommitWithDiff(commit, ancestor *object.Commit, tw *table.Writer, refHashToName map[string][]string, pa *ParsedArgs) {
-	hash := prettyHash(commit)
-	relTime := prettyRelativeTime(commit)
-	author := prettyAuthor(commit)
-	diff, _ := prettyDiff(commit, ancestor, pa)
-	message := prettyMessage(commit, refHashToName)
-	(*tw).AppendRow(table.Row{hash, relTime, author, diff, message})
-}
-
-func prettyHash(commit *object.Commit) string {
-	return color.YellowString(commit.Hash.String()[:7])
-}
-func prettyRelativeTime(commit *object.Commit) string {
-	return color.GreenString(gotime.TimeAgo(commit.Author.When))
-}
-func prettyAuthor(commit *object.Commit) string {
-	return color.New(color.FgBlue).Add(color.Bold).Sprint(commit.Author.Name)
-}
-func prettyMessage(commit *object.Commit, refHashToName map[string][]string) string {
-	messageLines := strings.SplitN(commit.Message, "\n", 2)
-	message := strings.TrimSpace(messageLines[0])
-	var refName string
-	if refNames, ok := refHashToName[commit.Hash.String()]; ok {
-		formattedRefNames := make([]string, 0, len(refNames))
-		for _, rn := range refNames {
-			formattedRefNames = append(formattedRefNames, color.RedString("(%s)", rn))
+// runRepos shows the log of each of several repositories in turn, under a
+// header naming it, parsing the command line afresh for each so every one
+// resolves its own base and reads its own rc file. A repository that fails
+// is reported and skipped. The exit code is prettylog.ExitError if any failed, or else
+// prettylog.ExitOK if any had commits to show, or prettylog.ExitNoCommits if all were empty.
+func runRepos(name string, argv []string, repoPaths []string, out io.Writer, stderr io.Writer) int {
+	code := prettylog.ExitNoCommits
+	headed := false
+	for _, repoPath := range repoPaths {
+		pa, opts, err := parseArgs(name, argv, repoPath, stderr)
+		if errors.Is(err, prettylog.ErrNoCommits) {
+			fmt.Fprintf(stderr, "%s: %s\n", repoPath, err.Error())
+			continue
+		} else if err != nil {
+			fmt.Fprintf(stderr, "%s: error parsing args: %s\n", repoPath, err.Error())
+			code = prettylog.ExitError
+			continue
+		}
+		if !opts.Quiet {
+			if headed {
+				fmt.Fprintln(out)
+			}
+			headed = true
+			if opts.Format == "markdown" {
+				fmt.Fprintf(out, "## %s\n\n", repoPath)
+			} else {
+				fmt.Fprintln(out, color.New(color.Bold).Sprint(repoPath))
+			}
+		}
+		repoCode, err := pa.Run(out)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", repoPath, err.Error())
+		}
+		if repoCode == prettylog.ExitError || code == prettylog.ExitError {
+			code = prettylog.ExitError
+		} else if repoCode == prettylog.ExitOK {
+			code = prettylog.ExitOK
 		}
-		refName = strings.Join(formattedRefNames, "")
-		return fmt.Sprintf("%s %s", refName, message)
-	} else {
-		return message
 	}
+	return code
 }
 
-var shortstatRE = regexp.MustCompile(`(?:(\d+)\s+files?\s+changed)?(?:,\s+(\d+)\s+insertions?\(\+\))?(?:,\s+(\d+)\s+deletions?\(-\))?`)
-
-func prettyDiff(commit, ancestor *object.Commit, pa *ParsedArgs) (string, error) {
-	args := []string{
-		"diff",
-		"--shortstat",
-		ancestor.Hash.String(),
-		commit.Hash.String(),
-		"--",
-		".",
+// errUsage marks errors in the command line that the flag set has already
+// reported, along with the usage.
+var errUsage = errors.New("invalid usage")
+
+// parseRepoPaths parses argv only to find the repositories given with
+// --repo-path, of which there may be several, defaulting to the current
+// directory, and whether to page the output. Usage errors are reported to
+// stderr here, before any repository is opened.
+func parseRepoPaths(name string, argv []string, stderr io.Writer) ([]string, bool, error) {
+	args := cliArgs{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	defineFlags(fs, &args)
+	if err := fs.Parse(argv); errors.Is(err, flag.ErrHelp) {
+		return nil, false, err
+	} else if err != nil {
+		return nil, false, fmt.Errorf("%w: %w", errUsage, err)
 	}
-	for _, pathspec := range pa.exclude {
-		args = append(args, fmt.Sprintf(":^%s", pathspec))
+	if args.version {
+		return nil, false, errVersion
 	}
-	cmd := exec.Command("git", args...)
-	cmd.Dir = pa.repoPath
-	ba, err := cmd.Output()
-	if err != nil {
-		return "", err
+	// pipes are never paged, nor is output that is streamed or suppressed
+	paging := !args.noPager && !args.opts.FollowCommits && !args.opts.Quiet && prettylog.StdoutIsTerminal()
+	if len(args.repoPaths) == 0 {
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, false, err
+		}
+		return []string{wd}, paging, nil
 	}
-	matches := shortstatRE.FindStringSubmatch(strings.TrimSpace(string(ba)))
-	if len(matches) == 0 {
-		return "", nil
+	if len(args.repoPaths) > 1 {
+		if args.opts.FollowCommits {
+			return nil, false, errors.New("--follow-commits follows a single repository and can't be given several --repo-path")
+		}
+		if args.opts.Format != "table" && args.opts.Format != "markdown" {
+			return nil, false, fmt.Errorf("several --repo-path can't be combined with --format %s; only table and markdown are supported", args.opts.Format)
+		}
 	}
-	var totalFiles, totalAdded, totalDeleted string
-	if matches[1] != "" {
-		totalFiles = matches[1]
+	return args.repoPaths, paging, nil
+}
+
+// parseArgs parses the command line arguments argv, which exclude the program
+// name, for the repository at repoPath, or when it's "" the one given with
+// --repo-path or else the current directory. Usage and warnings are reported
+// to stderr. The options are returned along with the log they were parsed
+// into.
+func parseArgs(name string, argv []string, repoPath string, stderr io.Writer) (*prettylog.Log, prettylog.Options, error) {
+	args := cliArgs{}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	defineFlags(fs, &args)
+	if err := fs.Parse(argv); errors.Is(err, flag.ErrHelp) {
+		return nil, args.opts, err
+	} else if err != nil {
+		return nil, args.opts, fmt.Errorf("%w: %w", errUsage, err)
 	}
-	if matches[2] != "" {
-		totalAdded = matches[2]
+	switch {
+	case repoPath != "":
+		args.opts.RepoPath = repoPath
+	case len(args.repoPaths) > 0:
+		args.opts.RepoPath = args.repoPaths[0]
+	default:
+		wd, err := os.Getwd()
+		if err != nil {
+			return nil, args.opts, err
+		}
+		args.opts.RepoPath = wd
 	}
-	if matches[3] != "" {
-		totalDeleted = matches[3]
+	if err := applyRCFiles(fs, args.opts.RepoPath); err != nil {
+		return nil, args.opts, err
 	}
 
-	parts := make([]string, 0, 3)
-	if totalFiles != "" {
-		parts = append(parts, color.YellowString("%s(~)", totalFiles))
-	}
-	if totalAdded != "" {
-		parts = append(parts, color.GreenString("%s(+)", totalAdded))
-	}
-	if totalDeleted != "" {
-		parts = append(parts, color.RedString("%s(-)", totalDeleted))
+	pa, err := args.opts.Parse(stderr)
+	return pa, args.opts, err
+}
+
+// defineFlags registers the command line flags on fs, binding them to the
+// fields of args.
+func defineFlags(fs *flag.FlagSet, args *cliArgs) {
+	// Short and long forms of a flag share one variable, so when both are
+	// provided the one that comes last on the command line wins, and
+	// repeated excludes accumulate across both forms
+	addRepoPath := func(path string) error {
+		args.repoPaths = append(args.repoPaths, path)
+		return nil
 	}
-	return strings.Join(parts, ","), nil
+	fs.Func("repo-path", "The path of the git repository (default the current directory); can be repeated to show the logs of several repositories one after another", addRepoPath)
+	fs.Func("r", "The path of the git repository (default the current directory); can be repeated to show the logs of several repositories one after another", addRepoPath)
+	fs.BoolVar(&args.version, "version", false, "Print the version, commit and build date and exit")
+	fs.BoolVar(&args.noPager, "no-pager", false, "Don't page output to a terminal through $GIT_PAGER, core.pager, $PAGER or less")
+
+	args.opts.DefineFlags(fs)
 }
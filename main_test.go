@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pmwals09/git-pretty-log/prettylog"
+)
+
+// newRepo creates a repository, with one commit unless empty. HOME is pointed
+// at an empty directory so neither the user's git config nor their rc file
+// can change what the tests see.
+func newRepo(t *testing.T, empty bool) (string, *git.Repository) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("GIT_PAGER", "")
+	t.Setenv("NO_COLOR", "1")
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if empty {
+		return dir, repo
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	sig := &object.Signature{Name: "Alice Smith", Email: "alice@example.com", When: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	if _, err := wt.Commit("initial commit", &git.CommitOptions{Author: sig, Committer: sig}); err != nil {
+		t.Fatal(err)
+	}
+	return dir, repo
+}
+
+func TestShortAndLongFlags(t *testing.T) {
+	// the flags of interest, from both the command and the log's options
+	type args struct {
+		repoPaths     []string
+		baseName      string
+		numberCommits int
+		exclude       []string
+	}
+	tests := []struct {
+		name string
+		argv []string
+		want args
+	}{
+		{name: "no flags", want: args{numberCommits: 30}},
+		{name: "-r", argv: []string{"-r", "x"}, want: args{repoPaths: []string{"x"}, numberCommits: 30}},
+		{name: "--repo-path", argv: []string{"--repo-path", "y"}, want: args{repoPaths: []string{"y"}, numberCommits: 30}},
+		// each repository given is shown in turn
+		{name: "-r and --repo-path", argv: []string{"-r", "x", "--repo-path", "y"}, want: args{repoPaths: []string{"x", "y"}, numberCommits: 30}},
+		{name: "-b", argv: []string{"-b", "x"}, want: args{baseName: "x", numberCommits: 30}},
+		{name: "--base", argv: []string{"--base", "y"}, want: args{baseName: "y", numberCommits: 30}},
+		{name: "-b then --base", argv: []string{"-b", "x", "--base", "y"}, want: args{baseName: "y", numberCommits: 30}},
+		{name: "--base then -b", argv: []string{"--base", "y", "-b", "x"}, want: args{baseName: "x", numberCommits: 30}},
+		{name: "-n", argv: []string{"-n", "5"}, want: args{numberCommits: 5}},
+		{name: "--num-commits", argv: []string{"--num-commits", "7"}, want: args{numberCommits: 7}},
+		{name: "-n then --num-commits", argv: []string{"-n", "5", "--num-commits", "7"}, want: args{numberCommits: 7}},
+		{name: "--num-commits then -n", argv: []string{"--num-commits", "7", "-n", "5"}, want: args{numberCommits: 5}},
+		{name: "-e", argv: []string{"-e", "a"}, want: args{exclude: []string{"a"}, numberCommits: 30}},
+		{name: "--exclude", argv: []string{"--exclude", "b"}, want: args{exclude: []string{"b"}, numberCommits: 30}},
+		{name: "-e and --exclude", argv: []string{"-e", "a", "--exclude", "b", "-e", "c"}, want: args{exclude: []string{"a", "b", "c"}, numberCommits: 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("git-pretty-log", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			var cli cliArgs
+			defineFlags(fs, &cli)
+			if err := fs.Parse(tt.argv); err != nil {
+				t.Fatal(err)
+			}
+			got := args{repoPaths: cli.repoPaths, baseName: cli.opts.BaseName, numberCommits: cli.opts.NumberCommits, exclude: cli.opts.Exclude}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunReposEmpty(t *testing.T) {
+	empty, _ := newRepo(t, true)
+	full, _ := newRepo(t, false)
+	tests := []struct {
+		name  string
+		repos []string
+		code  int
+	}{
+		{name: "all empty", repos: []string{empty, empty}, code: prettylog.ExitNoCommits},
+		{name: "one with commits", repos: []string{empty, full}, code: prettylog.ExitOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, stderr bytes.Buffer
+			if code := runRepos("git-pretty-log", nil, tt.repos, &out, &stderr); code != tt.code {
+				t.Errorf("exit code %d, want %d\n%s", code, tt.code, stderr.String())
+			}
+			if !strings.Contains(stderr.String(), "repository has no commits yet") {
+				t.Errorf("the empty repository wasn't reported: %q", stderr.String())
+			}
+		})
+	}
+}
+
+func TestPagerCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		// corePager is written to the repository's config unless nil
+		corePager *string
+		want      string
+	}{
+		{name: "default", want: "less"},
+		{name: "PAGER", env: map[string]string{"PAGER": "most"}, want: "most"},
+		{name: "core.pager over PAGER", env: map[string]string{"PAGER": "most"}, corePager: ptr("less -S"), want: "less -S"},
+		{name: "GIT_PAGER over core.pager", env: map[string]string{"GIT_PAGER": "lv"}, corePager: ptr("less -S"), want: "lv"},
+		{name: "cat", env: map[string]string{"PAGER": "cat"}, want: ""},
+		{name: "empty GIT_PAGER", env: map[string]string{"GIT_PAGER": ""}, want: ""},
+		{name: "empty core.pager", env: map[string]string{"PAGER": "most"}, corePager: ptr(""), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, repo := newRepo(t, true)
+			// set first so they're restored afterwards
+			t.Setenv("PAGER", "")
+			os.Unsetenv("GIT_PAGER")
+			os.Unsetenv("PAGER")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			if tt.corePager != nil {
+				cfg, err := repo.Config()
+				if err != nil {
+					t.Fatal(err)
+				}
+				cfg.Raw.Section("core").SetOption("pager", *tt.corePager)
+				if err := repo.SetConfig(cfg); err != nil {
+					t.Fatal(err)
+				}
+			}
+			opened, err := prettylog.OpenRepository(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := pagerCommand(opened); got != tt.want {
+				t.Errorf("pager is %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestPagerEnviron(t *testing.T) {
+	t.Setenv("LESS", "")
+	os.Unsetenv("LESS")
+	t.Setenv("LV", "-z")
+	env := pagerEnviron()
+	if !slices.Contains(env, "LESS=FRX") {
+		t.Error("LESS isn't set to FRX for the pager")
+	}
+	// git leaves settings of the user's own alone
+	if !slices.Contains(env, "LV=-z") || slices.Contains(env, "LV=-c") {
+		t.Error("LV was overridden")
+	}
+}
@@ -0,0 +1,4535 @@
+// Package prettylog shows the log of a git repository the way git-pretty-log
+// does: each commit with its diff against a base branch, as a table or in one
+// of the machine-readable formats. The git-pretty-log command is a thin
+// wrapper around it, parsing its flags into Options and paging the output.
+package prettylog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"math"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
+	linediff "github.com/go-git/go-git/v5/utils/diff"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/maniartech/gotime"
+	"github.com/mattn/go-isatty"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"golang.org/x/term"
+)
+
+// Options configure a log. Each field is the value of a command line flag of
+// git-pretty-log, which DefineFlags binds them to and whose usage documents
+// the field, but for RepoPath, the repository to show the log of. The zero
+// Options aren't valid; DefaultOptions has the flags' defaults.
+type Options struct {
+	BaseName       string
+	BaseCandidates []string
+	RemoteBase     bool
+	RangeSpec      string
+	NoMerges       bool
+	MergesOnly     bool
+	Links          bool
+	CommitterDate  bool
+	Plain          bool
+	Since          string
+	Until          string
+	NumberCommits  int
+	RepoPath       string
+	Exclude        []string
+	Include        []string
+	Paths          []string
+	Follow         bool
+	Author         []string
+	Grep           string
+	GrepAllMatch   bool
+	GrepBody       string
+	Date           string
+	DateLabels     string
+	Snapshot       string
+	Divergence     bool
+	FullRefs       bool
+	ByWeek         bool
+	DiffAgainst    string
+	CwdOnly        bool
+	Sizes          bool
+	Pickaxe        string
+	PickaxeRegex   string
+	AuthorTZ       bool
+	RequireChanges bool
+	Format         string
+	Template       string
+	ShowBase       bool
+	DiffStyle      string
+	Cochange       bool
+	FollowCommits  bool
+	SinceRelease   bool
+	ReleasePattern string
+	Color          string
+	Jobs           int
+	Summary        bool
+	NameStat       bool
+	Reverse        bool
+	Conventional   bool
+	Status         bool
+	Signatures     bool
+	Keyring        string
+	Full           bool
+	Trailers       bool
+	AuthorFormat   string
+	ColorAuthors   bool
+	HighlightMe    bool
+	Me             string
+	Verbose        bool
+	Legend         bool
+	Width          int
+	Columns        string
+	Oneline        bool
+	Bar            bool
+	Net            bool
+	RenameScore    RenameScore
+	GroupByDay     bool
+	Worktree       bool
+	NoDiff         bool
+	All            bool
+	Reflog         bool
+	Quiet          bool
+	Skip           int
+	MinChanges     int
+	MaxChanges     int
+	FirstParent    bool
+	FoldMerges     bool
+	Unfold         bool
+	// abbrevLen is how many digits of hashes to show, worked out by Parse
+	// from Abbrev, FullHash and git config
+	abbrevLen int
+	// Abbrev is --abbrev as given: a length, 0 for whole hashes, or auto
+	Abbrev   string
+	FullHash bool
+}
+
+// DefineFlags registers the command line flags of git-pretty-log on fs,
+// binding them to the fields of o and setting those to the flags' defaults.
+func (o *Options) DefineFlags(fs *flag.FlagSet) {
+	// Short and long forms of a flag share one variable, so when both are
+	// provided the one that comes last on the command line wins, and
+	// repeated excludes accumulate across both forms
+	fs.StringVar(&o.BaseName, "base", "", "The commit against which to compare (default $GIT_PRETTY_LOG_BASE, then gitprettylog.base from git config, then the repository's default branch)")
+	fs.StringVar(&o.BaseName, "b", "", "The commit against which to compare (default $GIT_PRETTY_LOG_BASE, then gitprettylog.base from git config, then the repository's default branch)")
+	fs.StringVar(&o.RangeSpec, "range", "", "Show only the commits in a range A..B, like git log A..B, diffed against where B forked from A. Replaces --base")
+	fs.BoolVar(&o.RemoteBase, "remote-base", false, "When no base is given or configured, compare against origin's copy of the base branch even where the local one is ahead; it is used anyway when the local one is behind")
+	fs.Var((*stringlist)(&o.BaseCandidates), "base-candidates", "A branch name to try as the base when --base isn't given, ahead of origin/HEAD, init.defaultBranch, main and master; can be repeated")
+
+	fs.IntVar(&o.NumberCommits, "num-commits", 30, "The number of commits to display. Note that a large number will degrade performance")
+	fs.IntVar(&o.NumberCommits, "n", 30, "The number of commits to display. Note that a large number will degrade performance")
+	fs.IntVar(&o.NumberCommits, "max-count", 30, "The number of commits to display, as in git log --max-count")
+
+	fs.BoolVar(&o.GroupByDay, "group-by-day", false, "Put a header with the date above the commits authored on each day, e.g. for a standup; with --reverse it reads chronologically")
+	fs.BoolVar(&o.Worktree, "worktree", false, "Add a row for the changes not yet committed, staged or not, above the newest commit")
+	fs.BoolVar(&o.Reverse, "reverse", false, "Show the oldest commits first, for reading a branch's history in the order it was written")
+	fs.IntVar(&o.Skip, "skip", 0, "The number of commits to skip before starting to display, for paging back through history with --num-commits")
+
+	fs.Var((*stringlist)(&o.Exclude), "exclude", "a valid [pathspec](https://git-scm.com/docs/gitglossary#Documentation/gitglossary.txt-aiddefpathspecapathspec) to exclude from diffing calculations; can be repeated")
+	fs.Var((*stringlist)(&o.Exclude), "e", "a valid [pathspec](https://git-scm.com/docs/gitglossary#Documentation/gitglossary.txt-aiddefpathspecapathspec) to exclude from diffing calculations; can be repeated")
+	fs.Var((*stringlist)(&o.Include), "include", "a valid pathspec to limit diffing calculations to, with --exclude then leaving paths out of it; can be repeated")
+	fs.Var((*stringlist)(&o.Paths), "path", "Only show commits that touch the given pathspec, like git log -- <path>; can be repeated to match any of several paths")
+	fs.BoolVar(&o.Follow, "follow", false, "With a single --path naming a file, follow its history back through renames, diffing only that file, like git log --follow")
+
+	fs.StringVar(&o.Since, "since", "", "Only show commits authored at or after a date, e.g. 2024-01-02, \"2024-01-02 15:04\", yesterday or \"7 days ago\"")
+	fs.StringVar(&o.Until, "until", "", "Only show commits authored at or before a date, in the same forms as --since. A date alone includes that whole day")
+
+	fs.IntVar(&o.MinChanges, "min-changes", 0, "Hide commits whose diff changes fewer lines than this, once excludes are applied, to leave out trivial commits. Hidden commits don't count towards --num-commits or --skip")
+	fs.IntVar(&o.MaxChanges, "max-changes", 0, "Hide commits whose diff changes more lines than this, once excludes are applied (default no limit)")
+	fs.BoolVar(&o.All, "all", false, "Show commits reachable from any branch, remote branch or tag, not just HEAD, newest first like git log --all")
+	fs.BoolVar(&o.Reflog, "reflog", false, "List where HEAD has been, newest first like git reflog, with the diff of each move, instead of the commit history")
+	fs.BoolVar(&o.NoMerges, "no-merges", false, "Don't show merge commits")
+	fs.BoolVar(&o.MergesOnly, "merges-only", false, "Only show merge commits")
+
+	fs.Var((*stringlist)(&o.Author), "author", "Only show commits whose author name or email contains the given text, ignoring case; can be repeated to match any of several authors")
+	fs.Var((*stringlist)(&o.Author), "a", "Only show commits whose author name or email contains the given text, ignoring case; can be repeated to match any of several authors")
+
+	fs.StringVar(&o.Grep, "grep", "", "Only show commits whose subject matches the given regular expression")
+	fs.BoolVar(&o.GrepAllMatch, "grep-all-match", false, "Match --grep against the full commit message instead of just the subject")
+
+	fs.StringVar(&o.GrepBody, "grep-body", "", "Only show commits whose full message matches the given regular expression, displaying the matching body line beneath the subject")
+
+	fs.StringVar(&o.Date, "date", "", "How to display commit dates: relative, relative-human, iso, short, or a gotime layout like yyyy-mm-dd hh:ii (default log.date from git config, or relative)")
+	fs.StringVar(&o.DateLabels, "date-labels", "", "Comma-separated overrides for relative-human dates, e.g. today=Today,yesterday=Yesterday,this-week=www,older=mmm d. The layouts are written like those of --date")
+
+	fs.StringVar(&o.Snapshot, "snapshot", "", "A fixed revision to diff every displayed commit against, instead of the base")
+
+	fs.BoolVar(&o.Divergence, "show-divergence", false, "Summarize how the current branch differs from its remote-tracking branch, e.g. after an amend or force-push")
+
+	fs.BoolVar(&o.FullRefs, "full-refs", false, "Decorate commits with every ref, not just branches, remote branches and tags. Slow in repos with many refs")
+
+	fs.BoolVar(&o.ByWeek, "by-week", false, "Summarize the walked commits by ISO week, showing the commit count and churn of each week, as a table or, with --format json, as json")
+
+	fs.StringVar(&o.DiffAgainst, "diff-against", "base", "What to diff each commit against: base, parent for each commit's own changes like git log --stat, or prev-shown for the previously displayed (older) commit")
+	fs.StringVar(&o.DiffAgainst, "diff-mode", "base", "Another name for --diff-against")
+
+	fs.BoolVar(&o.CwdOnly, "cwd-only", false, "Limit diff stats to the repo-path directory instead of the whole repository")
+
+	fs.BoolVar(&o.Sizes, "sizes", false, "Show the size of the file contents each commit added, and the largest commit. Slow on large histories")
+
+	fs.StringVar(&o.Pickaxe, "pickaxe", "", "Only show commits that change the number of occurrences of the given string, like git log -S. Slow on large histories")
+	fs.StringVar(&o.PickaxeRegex, "pickaxe-regex", "", "Only show commits that add or remove a line matching the given regular expression, like git log -G. Slow on large histories")
+
+	fs.BoolVar(&o.AuthorTZ, "author-tz", false, "Also show the time of day in the author's own timezone, with its UTC offset")
+
+	fs.BoolVar(&o.Quiet, "quiet", false, "Print nothing and only set the exit status: 0 when commits were found, 3 when none matched or the repository has none, 1 on errors")
+	fs.BoolVar(&o.Quiet, "q", false, "Print nothing and only set the exit status: 0 when commits were found, 3 when none matched or the repository has none, 1 on errors")
+	fs.BoolVar(&o.RequireChanges, "require-changes", false, "Exit non-zero if HEAD has no changes against the base once excludes are applied. The summary goes to stderr with --template and the json, jsonl, csv and dot formats")
+
+	fs.StringVar(&o.Format, "format", "table", "The output format: one of "+strings.Join(validFormats, ", "))
+	fs.StringVar(&o.Format, "f", "table", "The output format: one of "+strings.Join(validFormats, ", "))
+
+	fs.StringVar(&o.Template, "template", "", "A Go text/template executed for each commit in place of the table, like git log --format, e.g. '{{.ShortHash}} {{.Subject}}'. Fields: Hash, ShortHash, Author, Email, When, Subject, Body, Refs, FilesChanged, Insertions, Deletions and BinaryFiles")
+	fs.BoolVar(&o.ShowBase, "show-base", false, "Print the resolved base above the log, by ref name where possible")
+
+	fs.StringVar(&o.DiffStyle, "diff", "text", "How to show diff stats: text, bar for a bar scaled to the largest change shown, or net for lines added less lines removed")
+	fs.BoolVar(&o.NoDiff, "no-diff", false, "Don't compute or show diff stats, for a fast log of large histories")
+	fs.BoolVar(&o.Bar, "bar", false, "Short for --diff=bar")
+	fs.BoolVar(&o.Net, "net", false, "Short for --diff=net")
+
+	fs.BoolVar(&o.Cochange, "cochange", false, "Instead of the log, show which pairs of files were most often changed in the same walked commit")
+
+	fs.BoolVar(&o.FollowCommits, "follow-commits", false, "After rendering the log, keep running and append new commits as they are made, like tail -f")
+
+	fs.BoolVar(&o.Status, "status", false, "Print how many commits HEAD is ahead of and behind the base above the log")
+	fs.BoolVar(&o.SinceRelease, "since-release", false, "Print how many commits HEAD is ahead of the most recent reachable tag")
+	fs.StringVar(&o.ReleasePattern, "release-pattern", "*", "A glob the tag names considered by --since-release must match, e.g. v*")
+
+	fs.BoolVar(&o.FirstParent, "first-parent", false, "Follow only the first parent of merges, showing the mainline without the commits merged into it, like git log --first-parent")
+	fs.BoolVar(&o.FoldMerges, "fold-merges", false, "Follow only the first-parent line, folding the commits each merge brought in into a count on the merge")
+	fs.BoolVar(&o.Unfold, "unfold", false, "Like --fold-merges, but list the merged-in commits beneath each merge")
+
+	fs.BoolVar(&o.Summary, "summary", false, "Add a footer with the total files and lines changed across the shown commits")
+	fs.BoolVar(&o.Legend, "legend", false, "Print a key to what the colors of the table mean above it")
+	fs.BoolVar(&o.Verbose, "verbose", false, "Add a footer saying how many of the commits since the base were shown, e.g. to know whether to page on with --skip")
+	fs.BoolVar(&o.Verbose, "v", false, "Add a footer saying how many of the commits since the base were shown, e.g. to know whether to page on with --skip")
+	fs.IntVar(&o.Width, "width", 0, "Truncate messages so rows fit in this many columns, e.g. with --color=always when output isn't a terminal (default the terminal's width)")
+	fs.StringVar(&o.AuthorFormat, "author-format", "name", "How to show authors: one of "+strings.Join(validAuthorFormats, ", ")+", where initials keeps the column narrow")
+	fs.BoolVar(&o.ColorAuthors, "color-authors", false, "Color each author by a hash of their email, so their commits stand out the same way on every run, rather than showing every author in blue")
+	fs.BoolVar(&o.HighlightMe, "highlight-me", false, "Show the rows of commits authored by you, per user.email or else user.name in git config, in bold")
+	fs.StringVar(&o.Me, "me", "", "The email to highlight commits by, in place of user.email; implies --highlight-me")
+	fs.BoolVar(&o.Trailers, "trailers", false, "Credit co-authors from Co-authored-by trailers after the author, e.g. Jane Doe (+2), and add a column marking commits with Signed-off-by trailers")
+	fs.BoolVar(&o.Full, "full", false, "Show each commit's whole message, with its body and trailers beneath the subject, rather than just the subject")
+	fs.BoolVar(&o.Conventional, "conventional", false, "Show Conventional Commits prefixes like feat(ui): as a badge colored by type in place of the prefix")
+	o.RenameScore = defaultRenameScore
+	fs.Var(&o.RenameScore, "find-renames", "Count files at least this similar, in percent, as renamed rather than deleted and added, like git diff --find-renames=<n>; false turns detection off")
+	fs.BoolVar(&o.NameStat, "name-stat", false, "List each changed file with its lines added and removed beneath every commit's diff")
+	fs.IntVar(&o.Jobs, "jobs", runtime.GOMAXPROCS(0), "How many diffs to compute at once")
+	fs.StringVar(&o.Columns, "columns", "", "A comma-separated list of the columns to show, in order, from "+strings.Join(validColumns, ", ")+" (default the usual columns plus those asked for by other flags)")
+	fs.BoolVar(&o.Oneline, "oneline", false, "Show just the short hash and the subject with its refs, a space apart, like git log --oneline")
+	fs.BoolVar(&o.Signatures, "show-signature", false, "Add a column marking signed commits: a check when the signature verifies against --keyring, a question mark when it can't be verified")
+	fs.StringVar(&o.Keyring, "keyring", "", "A file of ASCII-armored PGP public keys to verify signatures against with --show-signature, e.g. from gpg --export --armor")
+	fs.BoolVar(&o.CommitterDate, "show-committer-date", false, "Add a column with when each commit was committed, next to when it was authored, which differ for rebased commits")
+	fs.BoolVar(&o.Plain, "plain", false, "Print tab-separated columns without color, as is done by default when output isn't a terminal and --color=always isn't given")
+	fs.StringVar(&o.Abbrev, "abbrev", "", "How many digits of hashes to show, 0 for whole hashes, or auto for as many as tell the commits shown apart (default log.abbrevCommit from git config, or 7)")
+	fs.BoolVar(&o.FullHash, "full-hash", false, "Show whole hashes, like --abbrev 0")
+	fs.BoolVar(&o.Links, "links", false, "Make hashes clickable links to the commit on GitHub or GitLab, for terminals that support OSC 8 hyperlinks")
+	fs.StringVar(&o.Color, "color", "auto", "When to color the output: auto, always or never. auto honors NO_COLOR and color.ui, and colors only terminals")
+}
+
+// DefaultOptions returns the options with the defaults of the flags of
+// git-pretty-log, for the repository in the current directory.
+func DefaultOptions() Options {
+	var o Options
+	o.DefineFlags(flag.NewFlagSet("git-pretty-log", flag.ContinueOnError))
+	return o
+}
+
+// Parse validates the options and resolves the commits they name, writing
+// any warnings to stderr.
+func (a Options) Parse(stderr io.Writer) (*Log, error) {
+	pa := Log{stderr: stderr, numberCommits: a.NumberCommits, repoPath: a.RepoPath, divergence: a.Divergence, fullRefs: a.FullRefs, byWeek: a.ByWeek, pickaxe: a.Pickaxe, authorTZ: a.AuthorTZ, requireChanges: a.RequireChanges, showBase: a.ShowBase, baseName: a.BaseName, cochange: a.Cochange, followCommits: a.FollowCommits, sinceRelease: a.SinceRelease, releasePattern: a.ReleasePattern, foldMerges: a.FoldMerges || a.Unfold, unfold: a.Unfold}
+	if a.Follow {
+		if len(a.Paths) != 1 || strings.ContainsAny(a.Paths[0], "*?[") {
+			return nil, errors.New("--follow traces a single file and needs exactly one --path naming it")
+		}
+		if a.Snapshot != "" || a.DiffAgainst == "prev-shown" {
+			return nil, errors.New("--follow shows each commit's own changes to the file and can't be combined with --snapshot or --diff-against=prev-shown")
+		}
+		a.DiffAgainst = "parent"
+	}
+	for _, author := range a.Author {
+		if author != "" {
+			pa.authors = append(pa.authors, strings.ToLower(author))
+		}
+	}
+
+	if a.Grep != "" {
+		re, err := regexp.Compile(a.Grep)
+		if err != nil {
+			return nil, fmt.Errorf("the provided grep pattern %s is invalid: %w", a.Grep, err)
+		}
+		pa.grep = re
+	}
+	pa.grepAllMatch = a.GrepAllMatch
+
+	if a.NumberCommits < 0 {
+		return nil, fmt.Errorf("the provided number of commits %d is invalid; must be at least 0", a.NumberCommits)
+	}
+	if a.Jobs < 1 {
+		return nil, fmt.Errorf("the provided number of jobs %d is invalid; must be at least 1", a.Jobs)
+	}
+	pa.jobs = a.Jobs
+	pa.summary = a.Summary
+	pa.verbose = a.Verbose
+	pa.legend = a.Legend
+	pa.nameStat = a.NameStat
+	pa.reverse = a.Reverse
+	pa.status = a.Status
+	pa.full = a.Full
+	pa.trailers = a.Trailers || a.Full
+	pa.renameScore = a.RenameScore
+	pa.groupByDay = a.GroupByDay
+	if a.Worktree && a.Format != "table" && a.Format != "markdown" {
+		return nil, fmt.Errorf("--worktree can't be combined with --format %s; only table and markdown are supported", a.Format)
+	}
+	pa.worktree = a.Worktree
+	pa.noDiff = a.NoDiff
+	if a.Oneline {
+		if a.Columns != "" {
+			return nil, errors.New("--oneline chooses the columns itself and can't be combined with --columns")
+		}
+		if a.Format != "table" {
+			return nil, fmt.Errorf("--oneline can't be combined with --format %s", a.Format)
+		}
+		a.Columns = "hash,message"
+	}
+	pa.oneline = a.Oneline
+	columns, err := resolveColumns(a)
+	if err != nil {
+		return nil, err
+	}
+	pa.columns = columns
+	// sizes are only computed for the column
+	pa.sizes = slices.Contains(columns, "size")
+	if a.Keyring != "" {
+		keyring, err := os.ReadFile(a.Keyring)
+		if err != nil {
+			return nil, fmt.Errorf("error reading keyring: %w", err)
+		}
+		pa.keyring = string(keyring)
+	}
+	pa.conventional = a.Conventional
+	if a.MinChanges < 0 || a.MaxChanges < 0 {
+		return nil, errors.New("--min-changes and --max-changes must not be negative")
+	}
+	if a.MaxChanges > 0 && a.MaxChanges < a.MinChanges {
+		return nil, fmt.Errorf("--max-changes %d is less than --min-changes %d", a.MaxChanges, a.MinChanges)
+	}
+	if (a.MinChanges > 0 || a.MaxChanges > 0) && a.NoDiff {
+		return nil, errors.New("--min-changes and --max-changes count the lines of diffs and can't be combined with --no-diff")
+	}
+	if (a.MinChanges > 0 || a.MaxChanges > 0) && a.DiffAgainst == "prev-shown" {
+		return nil, errors.New("--min-changes and --max-changes pick the commits shown and can't be combined with --diff-against=prev-shown, whose diffs depend on them")
+	}
+	pa.minChanges = a.MinChanges
+	pa.maxChanges = a.MaxChanges
+	if a.Skip < 0 {
+		return nil, fmt.Errorf("the provided number of commits to skip %d is invalid; must not be negative", a.Skip)
+	}
+	pa.skip = a.Skip
+	if a.NoMerges && a.MergesOnly {
+		return nil, errors.New("--no-merges and --merges-only are mutually exclusive")
+	}
+	pa.noMerges = a.NoMerges
+	pa.mergesOnly = a.MergesOnly
+	if a.All && a.RangeSpec != "" {
+		return nil, errors.New("--all and --range are mutually exclusive")
+	}
+	// folding merges is a first-parent walk too
+	pa.firstParent = a.FirstParent || pa.foldMerges
+	if a.All && pa.firstParent {
+		return nil, errors.New("--first-parent and --fold-merges follow HEAD's first parents and can't be combined with --all")
+	}
+	pa.all = a.All
+	if a.Reflog && (a.All || a.RangeSpec != "") {
+		return nil, errors.New("--reflog walks HEAD's reflog and can't be combined with --all or --range")
+	}
+	if a.Reflog && a.Format != "table" && a.Format != "markdown" {
+		return nil, fmt.Errorf("--reflog can't be combined with --format %s; only table and markdown are supported", a.Format)
+	}
+	pa.reflog = a.Reflog
+	if a.Quiet && a.FollowCommits {
+		return nil, errors.New("--quiet and --follow-commits are mutually exclusive")
+	}
+	pa.quiet = a.Quiet
+
+	now := time.Now()
+	if a.Since != "" {
+		since, err := parseDateFilter(a.Since, now, false)
+		if err != nil {
+			return nil, fmt.Errorf("the provided since date %s is invalid: %w", a.Since, err)
+		}
+		pa.since = since
+	}
+	if a.Until != "" {
+		until, err := parseDateFilter(a.Until, now, true)
+		if err != nil {
+			return nil, fmt.Errorf("the provided until date %s is invalid: %w", a.Until, err)
+		}
+		pa.until = until
+	}
+
+	if a.GrepBody != "" {
+		re, err := regexp.Compile(a.GrepBody)
+		if err != nil {
+			return nil, fmt.Errorf("the provided grep-body pattern %s is invalid: %w", a.GrepBody, err)
+		}
+		pa.grepBody = re
+	}
+
+	if a.PickaxeRegex != "" {
+		re, err := regexp.Compile(a.PickaxeRegex)
+		if err != nil {
+			return nil, fmt.Errorf("the provided pickaxe-regex pattern %s is invalid: %w", a.PickaxeRegex, err)
+		}
+		pa.pickaxeRegex = re
+	}
+
+	labels, err := parseHumanDateLabels(a.DateLabels)
+	if err != nil {
+		return nil, fmt.Errorf("the provided date labels %s are invalid: %w", a.DateLabels, err)
+	}
+	pa.dateLabels = labels
+
+	if !slices.Contains(validDiffAgainst, a.DiffAgainst) {
+		return nil, fmt.Errorf("the provided diff-against %s is invalid; must be one of %s", a.DiffAgainst, strings.Join(validDiffAgainst, ", "))
+	}
+	if a.DiffAgainst != "base" && a.Snapshot != "" {
+		return nil, fmt.Errorf("--snapshot and --diff-against=%s cannot be combined", a.DiffAgainst)
+	}
+	pa.diffAgainst = a.DiffAgainst
+
+	if _, err := path.Match(a.ReleasePattern, ""); err != nil {
+		return nil, fmt.Errorf("the provided release pattern %s is invalid: %w", a.ReleasePattern, err)
+	}
+
+	if !slices.Contains(validFormats, a.Format) {
+		return nil, fmt.Errorf("the provided format %s is invalid; must be one of %s", a.Format, strings.Join(validFormats, ", "))
+	}
+	if a.Format == "jsonl" && (a.Reverse || a.DiffAgainst == "prev-shown") {
+		return nil, errors.New("--format jsonl prints each commit as it is walked and can't be combined with --reverse or --diff-against=prev-shown")
+	}
+	if a.ByWeek && a.Format != "table" && a.Format != "markdown" && a.Format != "json" {
+		return nil, fmt.Errorf("--by-week summarizes the log as a table or json and can't be combined with --format %s", a.Format)
+	}
+	pa.format = a.Format
+
+	// parsed before the walk so a typo fails straight away
+	if a.Template != "" {
+		if a.Format != "table" {
+			return nil, fmt.Errorf("--template replaces the table and can't be combined with --format %s", a.Format)
+		}
+		tmpl, err := template.New("template").Parse(a.Template)
+		if err != nil {
+			return nil, fmt.Errorf("the provided template is invalid: %w", err)
+		}
+		pa.template = tmpl
+	}
+
+	if a.Bar && a.Net {
+		return nil, errors.New("--bar and --net are mutually exclusive")
+	}
+	if a.Bar {
+		a.DiffStyle = "bar"
+	}
+	if a.Net {
+		a.DiffStyle = "net"
+	}
+	if !slices.Contains(validDiffStyles, a.DiffStyle) {
+		return nil, fmt.Errorf("the provided diff style %s is invalid; must be one of %s", a.DiffStyle, strings.Join(validDiffStyles, ", "))
+	}
+	pa.diffStyle = a.DiffStyle
+
+	if !slices.Contains(validAuthorFormats, a.AuthorFormat) {
+		return nil, fmt.Errorf("the provided author format %s is invalid; must be one of %s", a.AuthorFormat, strings.Join(validAuthorFormats, ", "))
+	}
+	pa.authorFormat = a.AuthorFormat
+	pa.colorAuthors = a.ColorAuthors
+
+	repo, err := OpenRepository(a.RepoPath)
+	if err != nil {
+		return nil, err
+	}
+	// as in git, pathspecs are relative to the directory run from unless
+	// they start with :/
+	prefix, err := repoSubdir(repo, a.RepoPath)
+	if err != nil {
+		return nil, fmt.Errorf("error finding %s in the repository: %w", a.RepoPath, err)
+	}
+	if a.CwdOnly {
+		pa.scope = prefix
+	}
+	pa.exclude = make([]*regexp.Regexp, 0)
+	for _, pathspec := range a.Exclude {
+		if pathspec == "" {
+			continue
+		}
+		re, err := compilePathspec(pathspec, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("the provided exclude pathspec %s is invalid: %w", pathspec, err)
+		}
+		pa.exclude = append(pa.exclude, re)
+	}
+	// includes set the scope of diff stats, which excludes then carve out
+	// of, so an include that is itself excluded counts for nothing
+	kept := false
+	for _, pathspec := range a.Include {
+		if pathspec == "" {
+			continue
+		}
+		re, err := compilePathspec(pathspec, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("the provided include pathspec %s is invalid: %w", pathspec, err)
+		}
+		pa.include = append(pa.include, re)
+		dir := pathspecPath(pathspec, prefix)
+		if !slices.ContainsFunc(pa.exclude, func(re *regexp.Regexp) bool { return re.MatchString(dir) }) {
+			kept = true
+		}
+	}
+	if len(pa.include) > 0 && !kept {
+		return nil, fmt.Errorf("every --include pathspec is also excluded, leaving no paths to diff: %s", strings.Join(a.Include, ", "))
+	}
+	for _, pathspec := range a.Paths {
+		re, err := compilePathspec(pathspec, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("the provided path %s is invalid: %w", pathspec, err)
+		}
+		pa.paths = append(pa.paths, re)
+	}
+	if a.Follow {
+		pa.followPath = pathspecPath(a.Paths[0], prefix)
+	}
+	pa.repo = repo
+
+	if a.HighlightMe || a.Me != "" {
+		if err := resolveMe(&pa, a.Me); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkHead(repo); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case a.FullHash:
+		a.abbrevLen = len(plumbing.ZeroHash.String())
+	case a.Abbrev == "auto" && a.Format == "jsonl":
+		return nil, errors.New("--abbrev=auto needs every commit before the first is printed and can't be combined with --format jsonl")
+	case a.Abbrev == "auto":
+		// worked out once the commits to show are known
+		pa.autoAbbrev = true
+	case a.Abbrev != "":
+		n, err := strconv.Atoi(a.Abbrev)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("the provided abbrev %s is invalid; must be a number of digits, 0 for whole hashes, or auto", a.Abbrev)
+		}
+		// git shows at least 4 digits
+		a.abbrevLen = max(n, minAbbrev)
+		if n == 0 || n > len(plumbing.ZeroHash.String()) {
+			a.abbrevLen = len(plumbing.ZeroHash.String())
+		}
+	}
+
+	// flags take precedence over git config, which takes precedence over
+	// the tool's own defaults
+	if err := applyGitLogConfig(repo, &a); err != nil {
+		return nil, fmt.Errorf("error reading git log config: %w", err)
+	}
+	// anything that isn't a named mode is taken as a gotime layout, checked
+	// here so a typo fails up front instead of printing on every row
+	if !slices.Contains(validDateModes, a.Date) {
+		if err := validateDateLayout(a.Date); err != nil {
+			return nil, fmt.Errorf("the provided date format %s is invalid: %w; must be one of %s, or a layout such as yyyy-mm-dd hh:ii", a.Date, err, strings.Join(validDateModes, ", "))
+		}
+	}
+	pa.date = a.Date
+	pa.abbrev = a.abbrevLen
+
+	if !slices.Contains(validColorModes, a.Color) {
+		return nil, fmt.Errorf("the provided color mode %s is invalid; must be one of %s", a.Color, strings.Join(validColorModes, ", "))
+	}
+	if err := configureColor(repo, a.Color, a.Format); err != nil {
+		return nil, fmt.Errorf("error reading git color config: %w", err)
+	}
+	if a.Width < 0 {
+		return nil, fmt.Errorf("the provided width %d is invalid; must be at least 0", a.Width)
+	}
+	pa.width = a.Width
+	if pa.width == 0 && StdoutIsTerminal() {
+		if width, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			pa.width = width
+		}
+	}
+
+	// the padded table is for people; pipes get something cut and awk can
+	// split, unless color was asked for, which means a person is reading
+	pa.plain = a.Plain || (!StdoutIsTerminal() && a.Color != "always")
+	if pa.plain {
+		color.NoColor = true
+	}
+	// the escapes would end up as junk in files and pipes, even with
+	// --color=always
+	if a.Links && !pa.plain && StdoutIsTerminal() {
+		remote, err := repo.Remote("origin")
+		if err != nil && !errors.Is(err, git.ErrRemoteNotFound) {
+			return nil, fmt.Errorf("error reading origin remote: %w", err)
+		}
+		if remote != nil && len(remote.Config().URLs) > 0 {
+			pa.commitURL = forgeCommitURL(remote.Config().URLs[0])
+		}
+	}
+
+	// with --range the start of the range stands in for the base, and the
+	// end for HEAD
+	var rangeStart, rangeEnd *object.Commit
+	if a.RangeSpec != "" {
+		if a.BaseName != "" {
+			return nil, errors.New("--range and --base can't be combined; the start of the range is the base")
+		}
+		if a.FollowCommits {
+			return nil, errors.New("--follow-commits follows HEAD and can't be combined with --range")
+		}
+		rangeStart, rangeEnd, err = resolveRange(repo, a.RangeSpec)
+		if err != nil {
+			return nil, fmt.Errorf("the provided range %s is invalid: %w", a.RangeSpec, err)
+		}
+		pa.rangeExclude, err = ancestorSet(rangeStart)
+		if err != nil {
+			return nil, fmt.Errorf("error walking the start of range %s: %w", a.RangeSpec, err)
+		}
+	}
+
+	if a.RemoteBase && (a.BaseName != "" || a.RangeSpec != "") {
+		return nil, errors.New("--remote-base chooses the base and can't be combined with --base or --range")
+	}
+
+	// without --base, fall back to the configured default before guessing
+	if a.BaseName == "" && rangeStart == nil {
+		a.BaseName = os.Getenv("GIT_PRETTY_LOG_BASE")
+		if a.BaseName == "" {
+			a.BaseName, err = gitConfigOption(repo, "gitprettylog", "base")
+			if err != nil {
+				return nil, fmt.Errorf("error reading gitprettylog.base config: %w", err)
+			}
+		}
+		pa.baseName = a.BaseName
+	}
+
+	// check if the provided reference is valid
+	var baseCommit *object.Commit
+	if rangeStart != nil {
+		baseCommit = rangeStart
+	} else if a.BaseName == "" {
+		r, err := getBaseBranch(repo, a.BaseCandidates, a.RemoteBase)
+		if errors.Is(err, errNoBaseBranch) {
+			// still useful without a base: commits are listed without diffs
+			fmt.Fprintf(stderr, "warning: %s; showing commits without diffs\n", err)
+		} else if err != nil {
+			return nil, fmt.Errorf("error getting repo base branch: %w", err)
+		} else {
+			commit, err := repo.CommitObject(r.Hash())
+			if err != nil {
+				return nil, fmt.Errorf("error getting base branch commit: %w", err)
+			}
+			baseCommit = commit
+		}
+	} else {
+		hash, err := resolveRevision(repo, a.BaseName)
+		if err != nil {
+			return nil, fmt.Errorf("the provided base %s is invalid: %w", a.BaseName, err)
+		}
+		commit, err := peelToCommit(repo, hash)
+		if err != nil {
+			return nil, fmt.Errorf("error getting provided base %s commit: %w", a.BaseName, err)
+		}
+		baseCommit = commit
+	}
+
+	pa.baseCommit = baseCommit
+
+	// several features need where HEAD forked from the base, so find it once
+	headCommit := rangeEnd
+	if headCommit == nil {
+		head, err := repo.Head()
+		if err != nil {
+			return nil, fmt.Errorf("error resolving HEAD: %w", err)
+		}
+		headCommit, err = repo.CommitObject(head.Hash())
+		if err != nil {
+			return nil, fmt.Errorf("error getting HEAD commit: %w", err)
+		}
+	}
+	pa.headCommit = headCommit
+	if baseCommit != nil {
+		mbCommits, err := headCommit.MergeBase(baseCommit)
+		if err != nil {
+			return nil, fmt.Errorf("error finding merge base of HEAD and base: %w", err)
+		}
+		if len(mbCommits) > 0 {
+			pa.mergeBase = mbCommits[0]
+		}
+	}
+
+	if a.Snapshot != "" {
+		hash, err := resolveRevision(repo, a.Snapshot)
+		if err != nil {
+			return nil, fmt.Errorf("the provided snapshot %s is invalid: %w", a.Snapshot, err)
+		}
+		commit, err := peelToCommit(repo, hash)
+		if err != nil {
+			return nil, fmt.Errorf("error getting provided snapshot %s commit: %w", a.Snapshot, err)
+		}
+		pa.snapshotCommit = commit
+	}
+	return &pa, nil
+}
+
+// Log is a log ready to be shown, with its options validated and the commits
+// they name resolved.
+type Log struct {
+	// baseCommit is nil when no base was given and none could be found
+	baseCommit *object.Commit
+	// rangeExclude, with --range A..B, holds every commit reachable from A,
+	// none of which are shown
+	rangeExclude map[plumbing.Hash]bool
+	// baseName is the base as given on the command line, if any
+	baseName   string
+	headCommit *object.Commit
+	// mergeBase is the best common ancestor of HEAD and the base, or nil if
+	// they share no history
+	mergeBase     *object.Commit
+	numberCommits int
+	repo          *git.Repository
+	repoPath      string
+	// exclude matches the paths left out of diff stats
+	exclude []*regexp.Regexp
+	// include, when not empty, matches the only paths diff stats count,
+	// less those excluded
+	include []*regexp.Regexp
+	// paths match the paths a commit must touch to be shown, with --path
+	paths []*regexp.Regexp
+	// followPath, with --follow, is the file traced back through renames,
+	// under its name at HEAD
+	followPath string
+	// authors are lowercased substrings, any of which a commit's author
+	// name or email must contain to be shown
+	authors []string
+	grep    *regexp.Regexp
+	// grepAllMatch matches grep against the whole message rather than just
+	// the subject
+	grepAllMatch bool
+	grepBody     *regexp.Regexp
+	date         string
+	dateLabels   humanDateLabels
+	// snapshotCommit, when set, is the fixed ancestor every displayed
+	// commit is diffed against, bypassing the base reachability logic
+	snapshotCommit *object.Commit
+	divergence     bool
+	fullRefs       bool
+	byWeek         bool
+	diffAgainst    string
+	// scope is the directory diff stats are limited to, relative to the top
+	// of the repository, or "" for the whole repository
+	scope          string
+	sizes          bool
+	pickaxe        string
+	pickaxeRegex   *regexp.Regexp
+	authorTZ       bool
+	requireChanges bool
+	// stderr takes the summary of --require-changes when stdout is left to
+	// output meant to be parsed
+	stderr         io.Writer
+	format         string
+	showBase       bool
+	diffStyle      string
+	cochange       bool
+	followCommits  bool
+	sinceRelease   bool
+	releasePattern string
+	// jobs is how many diffs are computed at once
+	jobs     int
+	summary  bool
+	nameStat bool
+	reverse  bool
+	// status prints how far HEAD is ahead of and behind the base
+	status bool
+	// keyring holds the armored public keys signatures are checked against
+	keyring string
+	// columns are the names of the columns of the log table, in order
+	columns []string
+	// renameScore is the similarity threshold for renames in percent, or 0
+	// to not look for them
+	renameScore RenameScore
+	// groupByDay puts a header above the commits of each day
+	groupByDay bool
+	// worktree adds a row for the uncommitted changes
+	worktree bool
+	// noDiff shows every commit without a diff, so none are computed
+	noDiff bool
+	// oneline draws the table with a single space between its columns
+	oneline bool
+	// full shows whole commit messages rather than just their subjects
+	full bool
+	// trailers counts co-authors from Co-authored-by trailers, as does full
+	trailers bool
+	// authorFormat is how authors are shown: name, email or initials
+	authorFormat string
+	// colorAuthors gives each author their own color from authorColors
+	colorAuthors bool
+	// meEmail and meName, with --highlight-me, identify the user whose
+	// commits are emphasised; the email is matched when known
+	meEmail string
+	meName  string
+	// width is the width messages are truncated to fit rows in, or 0 to
+	// leave them whole
+	width int
+	// all walks the commits reachable from any ref rather than just HEAD
+	all bool
+	// reflog lists HEAD's reflog in place of the commit history
+	reflog bool
+	// quiet renders nothing, leaving only the exit code
+	quiet bool
+	// verbose adds how many commits there are beyond those shown
+	verbose bool
+	// legend prints a key to the colors above the table
+	legend bool
+	// template, with --template, is executed for each commit in place of
+	// the table
+	template *template.Template
+	// conventional shows Conventional Commits prefixes as colored badges
+	conventional bool
+	// skip is how many matching commits to pass over before showing any
+	skip int
+	// minChanges and maxChanges bound the lines a commit's diff must change
+	// for it to be shown; a maxChanges of 0 leaves it unbounded
+	minChanges int
+	maxChanges int
+	noMerges   bool
+	mergesOnly bool
+	// commitURL, with --links, is the forge URL a full hash is appended to
+	// to link to a commit, or "" when hashes aren't linked
+	commitURL string
+	// plain renders tables as tab-separated values for scripts
+	plain bool
+	// since and until bound the author dates of the commits shown; the zero
+	// time leaves that side open
+	since time.Time
+	until time.Time
+	// firstParent walks only the first-parent line, as does foldMerges
+	firstParent bool
+	foldMerges  bool
+	unfold      bool
+	// abbrev is how many digits of hashes to show
+	abbrev int
+	// autoAbbrev, with --abbrev=auto, sets abbrev to tell the commits shown
+	// apart
+	autoAbbrev bool
+}
+
+// Repository returns the repository the log is of.
+func (pa *Log) Repository() *git.Repository {
+	return pa.repo
+}
+
+// stringlist is a flag that can be repeated, collecting every value given.
+type stringlist []string
+
+func (s *stringlist) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringlist) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// Exit codes, so scripts can tell an empty log from a failure. 2 is left to
+// the command, for command lines it couldn't parse.
+const (
+	ExitOK = 0
+	// ExitError is for errors opening the repository or in the options
+	ExitError = 1
+	// ExitNoCommits is for when no commits matched the filters, or the
+	// repository has none at all
+	ExitNoCommits = 3
+)
+
+// Run shows the log of the repository at opts.RepoPath, or the current
+// directory, as git-pretty-log would with the same flags, writing it to out
+// and any warnings to stderr. It returns the code git-pretty-log would exit
+// with, along with what went wrong when that's ExitError, or ExitNoCommits
+// for a repository with no commits at all.
+func Run(opts Options, out, stderr io.Writer) (int, error) {
+	pa, err := opts.Parse(stderr)
+	if errors.Is(err, ErrNoCommits) {
+		return ExitNoCommits, err
+	} else if err != nil {
+		return ExitError, err
+	}
+	return pa.Run(out)
+}
+
+// Run walks the history of the repository and renders the resulting log to
+// out, returning the code to exit with, like the package's Run.
+func (pa *Log) Run(out io.Writer) (int, error) {
+	shown, err := runLog(pa, out)
+	if err != nil {
+		return ExitError, err
+	}
+	if shown == 0 {
+		return ExitNoCommits, nil
+	}
+	return ExitOK, nil
+}
+
+// runLog does the work of Run, returning how many commits or reflog entries
+// were shown.
+func runLog(pa *Log, out io.Writer) (int, error) {
+	repo := pa.repo
+
+	// Map local branch hashes to branch name
+	refHashToName, err := makeHashToNameMap(repo, pa.fullRefs)
+	if err != nil {
+		return 0, fmt.Errorf("error mapping ref hashes to names: %w", err)
+	}
+
+	if pa.reflog {
+		return printReflog(out, pa)
+	}
+
+	reachable := isBaseReachableFromHead(pa)
+
+	if pa.format == "jsonl" && !pa.quiet {
+		shown, err := printJSONL(out, refHashToName, reachable, pa)
+		if err != nil {
+			return shown, err
+		}
+		return shown, requireChanges(pa.stderr, pa)
+	}
+
+	entries, err := walkLog(pa, reachable, nil)
+	if err != nil {
+		return 0, err
+	}
+	if pa.autoAbbrev {
+		hashes := make([]plumbing.Hash, 0, len(entries))
+		for _, entry := range entries {
+			hashes = append(hashes, entry.commit.Hash)
+		}
+		pa.abbrev = uniqueAbbrev(hashes)
+	}
+
+	if pa.reverse && pa.format != "dot" {
+		// ancestors were paired up during the walk, so only the order the
+		// entries are shown in changes
+		slices.Reverse(entries)
+	}
+
+	if pa.quiet {
+		// only the exit code is wanted, though --require-changes still
+		// has its say in it
+		return len(entries), requireChanges(io.Discard, pa)
+	}
+
+	if pa.template != nil || pa.format == "dot" || pa.format == "json" || pa.format == "csv" {
+		if err := printFormatted(out, entries, refHashToName, pa); err != nil {
+			return len(entries), err
+		}
+		return len(entries), requireChanges(pa.stderr, pa)
+	}
+
+	if pa.showBase {
+		fmt.Fprintf(out, "base: %s\n", prettyBase(pa, refHashToName))
+	}
+	if pa.status {
+		if err := printStatus(out, pa, refHashToName); err != nil {
+			return len(entries), fmt.Errorf("error counting commits ahead of the base: %w", err)
+		}
+	}
+	if pa.sinceRelease {
+		if err := printSinceRelease(out, pa); err != nil {
+			return len(entries), fmt.Errorf("error counting commits since release: %w", err)
+		}
+	}
+	if pa.divergence {
+		if err := printDivergence(out, pa); err != nil {
+			return len(entries), fmt.Errorf("error determining divergence from remote: %w", err)
+		}
+	}
+
+	if pa.cochange {
+		if err := printCochanges(out, entries, pa); err != nil {
+			return len(entries), err
+		}
+		return len(entries), requireChanges(out, pa)
+	}
+
+	if pa.byWeek {
+		if err := printWeeks(out, entries, pa); err != nil {
+			return len(entries), err
+		}
+		return len(entries), requireChanges(out, pa)
+	}
+
+	var largest *logEntry
+	if pa.sizes {
+		for i := range entries {
+			size, err := commitBytesAdded(repo, entries[i].commit)
+			if err != nil {
+				return len(entries), fmt.Errorf("error computing size of %s: %w", entries[i].commit.Hash, err)
+			}
+			entries[i].bytesAdded = size
+			if largest == nil || size > largest.bytesAdded {
+				largest = &entries[i]
+			}
+		}
+	}
+
+	if pa.legend && pa.format == "table" {
+		printLegend(out, pa)
+	}
+	tw := getTableWriter(out)
+	if pa.oneline {
+		// git puts a single space between the hash and the subject
+		tw.Style().Box.PaddingLeft = ""
+		tw.Style().Box.PaddingRight = " "
+	}
+	if pa.format == "markdown" {
+		// markdown tables can't do without a header
+		tw.AppendHeader(headerRow(pa))
+	}
+	// the working tree is newer than any commit, so it leads the log
+	if pa.worktree && !pa.reverse {
+		if err := appendWorktreeRow(&tw, entries, pa); err != nil {
+			return len(entries), err
+		}
+	}
+	appendRows(entries, &tw, refHashToName, pa)
+	if pa.worktree && pa.reverse {
+		if err := appendWorktreeRow(&tw, entries, pa); err != nil {
+			return len(entries), err
+		}
+	}
+	fitMessages(tw, out, pa)
+	renderTable(tw, pa)
+
+	if pa.verbose {
+		if err := printShownCount(out, entries, pa); err != nil {
+			return len(entries), fmt.Errorf("error counting commits since base: %w", err)
+		}
+	}
+	if largest != nil {
+		fmt.Fprintf(out, "largest commit by bytes added: %s %s\n", prettyHash(largest.commit, pa), color.CyanString(humanBytes(largest.bytesAdded)))
+	}
+	if err := requireChanges(out, pa); err != nil {
+		return len(entries), err
+	}
+	if pa.followCommits {
+		return len(entries), followCommits(out, entries, refHashToName, pa)
+	}
+	return len(entries), nil
+}
+
+// printFormatted writes the entries with --template or a --format other than
+// the table ones. The output is meant to be parsed, so runLog leaves stdout
+// to it alone.
+func printFormatted(out io.Writer, entries []logEntry, refHashToName map[string][]plumbing.ReferenceName, pa *Log) error {
+	switch {
+	case pa.template != nil:
+		return printTemplate(out, entries, refHashToName, pa)
+	case pa.format == "dot":
+		return printDot(out, entries, pa)
+	case pa.format == "json" && pa.byWeek:
+		return printWeeks(out, entries, pa)
+	case pa.format == "json":
+		return printJSON(out, entries, refHashToName, pa)
+	default:
+		return printCSV(out, entries, refHashToName, pa)
+	}
+}
+
+// appendRows computes the diff stats of the entries and appends a row for
+// each to the table.
+func appendRows(entries []logEntry, tw *table.Writer, refHashToName map[string][]plumbing.ReferenceName, pa *Log) {
+	// stats are computed up front so bars can be scaled to the largest
+	maxChanges := computeStats(entries, pa)
+
+	var day string
+	for _, entry := range entries {
+		if pa.groupByDay {
+			// a header whenever the walk crosses into another day, in the
+			// local timezone like the relative dates
+			if d := entry.commit.Author.When.Local().Format(dayHeaderLayout); d != day {
+				appendDetailRow(color.New(color.Bold, color.Underline).Sprint(d), tw, pa)
+				day = d
+			}
+		}
+		if entry.hasDiff() {
+			printCommitWithDiff(entry, maxChanges, tw, refHashToName, pa)
+		} else {
+			printCommit(entry, tw, refHashToName, pa)
+		}
+		for _, f := range entry.files {
+			name := "  " + f.name
+			if f.renamed {
+				name += color.New(color.Faint).Sprint(" (renamed)")
+			}
+			fileDiff := diffStat{insertions: f.insertions, deletions: f.deletions}
+			if f.binary {
+				fileDiff.binaries = 1
+			}
+			(*tw).AppendRow(sparseRow(diffText(fileDiff, pa), name, pa))
+		}
+		for _, line := range entry.bodyContext {
+			appendDetailRow("  "+line, tw, pa)
+		}
+		if pa.unfold {
+			for _, c := range entry.merged {
+				appendDetailRow(fmt.Sprintf("  ↳ %s %s", prettyHash(c, pa), prettySubject(c)), tw, pa)
+			}
+		}
+	}
+
+	if total, ok := summaryStat(entries, pa); pa.summary && ok {
+		(*tw).AppendFooter(sparseRow(diffText(total, pa), "total", pa))
+	}
+}
+
+// appendWorktreeRow adds a row for the changes not yet committed, staged or
+// not, like git diff HEAD. It is set apart from the commits by having no hash
+// or author and a label in place of a message.
+func appendWorktreeRow(tw *table.Writer, entries []logEntry, pa *Log) error {
+	stat, err := worktreeStat(pa)
+	if err != nil {
+		return fmt.Errorf("error diffing the working tree: %w", err)
+	}
+	label := color.New(color.FgMagenta, color.Italic).Sprint("(working tree)")
+	if stat.files == 0 {
+		(*tw).AppendRow(sparseRow("", label+color.New(color.Faint).Sprint(" clean"), pa))
+		return nil
+	}
+	maxChanges := max(computeStats(entries, pa), stat.insertions+stat.deletions)
+	(*tw).AppendRow(sparseRow(prettyDiff(stat, maxChanges, pa), label, pa))
+	return nil
+}
+
+// worktreeStat diffs the files of the working tree against HEAD, leaving out
+// untracked files as git diff HEAD does, and honoring the paths diff stats
+// are limited to. Binary files count as changed without any lines.
+func worktreeStat(pa *Log) (diffStat, error) {
+	wt, err := pa.repo.Worktree()
+	if err != nil {
+		return diffStat{}, err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return diffStat{}, err
+	}
+	head, err := pa.repo.Head()
+	if err != nil {
+		return diffStat{}, err
+	}
+	headCommit, err := pa.repo.CommitObject(head.Hash())
+	if err != nil {
+		return diffStat{}, err
+	}
+
+	var stat diffStat
+	for name, fileStatus := range status {
+		if fileStatus.Worktree == git.Untracked || (fileStatus.Worktree == git.Unmodified && fileStatus.Staging == git.Unmodified) {
+			continue
+		}
+		if !pathInScope(name, pa) {
+			continue
+		}
+		var before, after string
+		if file, err := headCommit.File(name); err == nil {
+			if before, err = file.Contents(); err != nil {
+				return diffStat{}, err
+			}
+		} else if !errors.Is(err, object.ErrFileNotFound) {
+			return diffStat{}, err
+		}
+		if f, err := wt.Filesystem.Open(name); err == nil {
+			b, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return diffStat{}, err
+			}
+			after = string(b)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return diffStat{}, err
+		}
+		if before == after {
+			// e.g. only the mode changed, or a change was staged and then
+			// undone in the working tree
+			continue
+		}
+		stat.files++
+		if strings.ContainsRune(before, 0) || strings.ContainsRune(after, 0) {
+			stat.binaries++
+			continue
+		}
+		for _, d := range linediff.Do(before, after) {
+			switch d.Type {
+			case diffmatchpatch.DiffInsert:
+				stat.insertions += countLines(d.Text)
+			case diffmatchpatch.DiffDelete:
+				stat.deletions += countLines(d.Text)
+			}
+		}
+	}
+	return stat, nil
+}
+
+// dayHeaderLayout is the layout of the day headers of --group-by-day.
+const dayHeaderLayout = "Mon 2 Jan 2006"
+
+// summaryStat returns the aggregate change across the shown commits, or false
+// if none of them has a diff. With parent and prev-shown each row covers only
+// its own commits, so the rows add up; against the base every row is diffed
+// against the same ancestor, so the newest row already covers the rest.
+func summaryStat(entries []logEntry, pa *Log) (diffStat, bool) {
+	var total diffStat
+	found := false
+	for _, entry := range entries {
+		if entry.stat == nil {
+			continue
+		}
+		if pa.diffAgainst == "base" {
+			total = *entry.stat
+			found = true
+			if !pa.reverse {
+				break
+			}
+			// with --reverse the newest comes last
+			continue
+		}
+		total = total.Add(*entry.stat)
+		found = true
+	}
+	return total, found
+}
+
+// computeStats fills in the diff stats of every entry that has an ancestor
+// and returns the largest number of changed lines among them. Entries whose
+// diff fails are left without stats.
+//
+// Diffs are computed by up to pa.jobs workers, each filling in only its own
+// entries so the order they're shown in is unaffected. go-git's object
+// storage isn't safe for concurrent use, so every worker reads the commits
+// through its own copy of the repository.
+func computeStats(entries []logEntry, pa *Log) int {
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range min(pa.jobs, len(entries)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			repo, openErr := OpenRepository(pa.repoPath)
+			for i := range work {
+				if openErr != nil {
+					continue
+				}
+				// an entry whose diff fails is shown without one
+				statEntry(repo, &entries[i], pa)
+			}
+		}()
+	}
+	for i := range entries {
+		// stats already computed, e.g. for --min-changes, are kept
+		if entries[i].hasDiff() && entries[i].stat == nil {
+			work <- i
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	maxChanges := 0
+	for _, entry := range entries {
+		if entry.stat != nil {
+			maxChanges = max(maxChanges, entry.stat.insertions+entry.stat.deletions)
+		}
+	}
+	return maxChanges
+}
+
+// statEntry computes the diff stat of entry, and with --name-stat the stats
+// of its files, from the commits in repo. With --follow only the followed
+// file counts.
+func statEntry(repo *git.Repository, entry *logEntry, pa *Log) error {
+	files, err := fileStatsIn(repo, entry.commit, entry.ancestor, pa)
+	if err != nil {
+		return err
+	}
+	if path := entry.path; path != "" {
+		files = slices.DeleteFunc(files, func(f fileStat) bool {
+			return f.name != path && !strings.HasSuffix(f.name, " -> "+path)
+		})
+	}
+	stat := sumFileStats(files)
+	entry.stat = &stat
+	if pa.nameStat {
+		entry.files = files
+	}
+	return nil
+}
+
+// fileStatsIn is getFileStats with the commits read from repo.
+func fileStatsIn(repo *git.Repository, commit, ancestor *object.Commit, pa *Log) ([]fileStat, error) {
+	commit, err := repo.CommitObject(commit.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if ancestor != nil {
+		ancestor, err = repo.CommitObject(ancestor.Hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return getFileStats(commit, ancestor, pa)
+}
+
+// jsonCommit is the machine-readable form of a log entry. Hash is always the
+// full hash, regardless of how hashes are abbreviated for display, which is
+// what ShortHash carries. The diff fields are zero for commits shown without
+// a diff.
+type jsonCommit struct {
+	Hash         string    `json:"hash"`
+	ShortHash    string    `json:"shortHash"`
+	Author       string    `json:"author"`
+	AuthorEmail  string    `json:"authorEmail"`
+	When         time.Time `json:"when"`
+	Message      string    `json:"message"`
+	FilesChanged int       `json:"filesChanged"`
+	Insertions   int       `json:"insertions"`
+	Deletions    int       `json:"deletions"`
+	BinaryFiles  int       `json:"binaryFiles"`
+	Refs         []string  `json:"refs"`
+}
+
+func newJSONCommit(entry logEntry, refHashToName map[string][]plumbing.ReferenceName, pa *Log) jsonCommit {
+	c := entry.commit
+	jc := jsonCommit{
+		Hash:        c.Hash.String(),
+		ShortHash:   shortHash(c.Hash, pa),
+		Author:      c.Author.Name,
+		AuthorEmail: c.Author.Email,
+		When:        c.Author.When,
+		Message:     prettySubject(c),
+		Refs:        make([]string, 0),
+	}
+	if entry.stat != nil {
+		jc.FilesChanged = entry.stat.files
+		jc.Insertions = entry.stat.insertions
+		jc.Deletions = entry.stat.deletions
+		jc.BinaryFiles = entry.stat.binaries
+	}
+	jc.Refs = append(jc.Refs, shortRefNames(refHashToName[c.Hash.String()])...)
+	return jc
+}
+
+// printJSON writes the entries as a JSON array.
+func printJSON(out io.Writer, entries []logEntry, refHashToName map[string][]plumbing.ReferenceName, pa *Log) error {
+	computeStats(entries, pa)
+	commits := make([]jsonCommit, 0, len(entries))
+	for _, entry := range entries {
+		commits = append(commits, newJSONCommit(entry, refHashToName, pa))
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(commits); err != nil {
+		return fmt.Errorf("error writing json: %w", err)
+	}
+	return nil
+}
+
+// printJSONL streams the log as JSON lines, one object with the fields of the
+// JSON output per commit, writing each as soon as the walk reaches it so that
+// long histories can be piped into jq and the like without waiting for the
+// whole walk. Stdout isn't buffered, so each line goes out as it's written.
+// It returns how many commits were written.
+func printJSONL(out io.Writer, refHashToName map[string][]plumbing.ReferenceName, reachable bool, pa *Log) (int, error) {
+	enc := json.NewEncoder(out)
+	shown := 0
+	_, err := walkLog(pa, reachable, func(entry logEntry) error {
+		// what walkLog does for the collected entries once the walk is over
+		batch := []logEntry{entry}
+		if pa.diffAgainst == "parent" {
+			if err := pairWithParents(batch); err != nil {
+				return err
+			}
+		}
+		if pa.noDiff {
+			dropDiffs(batch)
+		}
+		entry = batch[0]
+		// computed here in the open repository rather than by
+		// computeStats, which would open it afresh for every line
+		if entry.hasDiff() && entry.stat == nil {
+			if err := statEntry(pa.repo, &entry, pa); err != nil {
+				return fmt.Errorf("error computing the diff of %s: %w", entry.commit.Hash, err)
+			}
+		}
+		if err := enc.Encode(newJSONCommit(entry, refHashToName, pa)); err != nil {
+			return fmt.Errorf("error writing json: %w", err)
+		}
+		shown++
+		return nil
+	})
+	return shown, err
+}
+
+// templateCommit is what --template is executed with for each commit.
+type templateCommit struct {
+	Hash         string
+	ShortHash    string
+	Author       string
+	Email        string
+	When         time.Time
+	Subject      string
+	Body         string
+	Refs         []string
+	FilesChanged int
+	Insertions   int
+	Deletions    int
+	BinaryFiles  int
+}
+
+// printTemplate executes the --template once for each entry, ending each with
+// a newline like git log --format.
+func printTemplate(out io.Writer, entries []logEntry, refHashToName map[string][]plumbing.ReferenceName, pa *Log) error {
+	computeStats(entries, pa)
+	for _, entry := range entries {
+		jc := newJSONCommit(entry, refHashToName, pa)
+		_, body, _ := strings.Cut(entry.commit.Message, "\n")
+		tc := templateCommit{
+			Hash:         jc.Hash,
+			ShortHash:    jc.ShortHash,
+			Author:       jc.Author,
+			Email:        jc.AuthorEmail,
+			When:         jc.When,
+			Subject:      jc.Message,
+			Body:         strings.TrimSpace(body),
+			Refs:         jc.Refs,
+			FilesChanged: jc.FilesChanged,
+			Insertions:   jc.Insertions,
+			Deletions:    jc.Deletions,
+			BinaryFiles:  jc.BinaryFiles,
+		}
+		if err := pa.template.Execute(out, tc); err != nil {
+			return fmt.Errorf("error executing template for %s: %w", jc.ShortHash, err)
+		}
+		fmt.Fprintln(out)
+	}
+	return nil
+}
+
+// printCSV writes the entries as CSV with a header row, for spreadsheets. It
+// has the same fields as the JSON output, less the refs.
+func printCSV(out io.Writer, entries []logEntry, refHashToName map[string][]plumbing.ReferenceName, pa *Log) error {
+	computeStats(entries, pa)
+	w := csv.NewWriter(out)
+	w.Write([]string{"hash", "date", "author", "email", "files", "insertions", "deletions", "subject", "binary_files"})
+	for _, entry := range entries {
+		jc := newJSONCommit(entry, refHashToName, pa)
+		w.Write([]string{
+			jc.Hash,
+			jc.When.Format(time.RFC3339),
+			jc.Author,
+			jc.AuthorEmail,
+			strconv.Itoa(jc.FilesChanged),
+			strconv.Itoa(jc.Insertions),
+			strconv.Itoa(jc.Deletions),
+			jc.Message,
+			strconv.Itoa(jc.BinaryFiles),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("error writing csv: %w", err)
+	}
+	return nil
+}
+
+// followDebounce is how long to wait for a burst of filesystem events, such as
+// the many ref updates of a rebase, to settle before looking at HEAD again.
+const followDebounce = 250 * time.Millisecond
+
+// followCommits watches the repository after the initial log is rendered and,
+// like tail -f, appends rows for commits that become reachable from HEAD,
+// oldest first, until interrupted. shown are the entries already rendered.
+func followCommits(out io.Writer, shown []logEntry, refHashToName map[string][]plumbing.ReferenceName, pa *Log) error {
+	storage, ok := pa.repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return errors.New("--follow-commits requires a repository on disk")
+	}
+	gitDir := storage.Filesystem().Root()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error watching repository: %w", err)
+	}
+	defer watcher.Close()
+	// HEAD lives in the git dir, and logs/HEAD is appended to whenever HEAD
+	// moves, including commits on the checked out branch
+	for _, dir := range []string{gitDir, filepath.Join(gitDir, "logs")} {
+		if err := watcher.Add(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("error watching %s: %w", dir, err)
+		}
+	}
+
+	seen := make(map[plumbing.Hash]bool, len(shown))
+	for _, entry := range shown {
+		seen[entry.commit.Hash] = true
+	}
+	lastHead := pa.headCommit.Hash
+
+	settle := time.NewTimer(followDebounce)
+	settle.Stop()
+	for {
+		select {
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return fmt.Errorf("error watching repository: %w", err)
+		case _, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			settle.Reset(followDebounce)
+		case <-settle.C:
+			head, err := pa.repo.Head()
+			if err != nil || head.Hash() == lastHead {
+				// HEAD can briefly be missing mid-rebase; wait for the next event
+				continue
+			}
+			lastHead = head.Hash()
+			headCommit, err := pa.repo.CommitObject(head.Hash())
+			if err != nil {
+				continue
+			}
+
+			// walk back from the new HEAD until reaching commits already shown
+			entries := make([]logEntry, 0)
+			err = object.NewCommitPreorderIter(headCommit, seen, nil).ForEach(func(c *object.Commit) error {
+				if len(entries) == pa.numberCommits {
+					return storer.ErrStop
+				}
+				entry := logEntry{commit: c}
+				if pa.snapshotCommit != nil {
+					entry.ancestor = pa.snapshotCommit
+				} else if isBaseReachableFromHead(pa) {
+					entry.ancestor = pa.mergeBase
+				}
+				entries = append(entries, entry)
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("error walking new commits: %w", err)
+			}
+			if len(entries) == 0 {
+				continue
+			}
+			for _, entry := range entries {
+				seen[entry.commit.Hash] = true
+			}
+			if pa.diffAgainst == "parent" {
+				if err := pairWithParents(entries); err != nil {
+					return err
+				}
+			}
+			if pa.noDiff {
+				dropDiffs(entries)
+			}
+			slices.Reverse(entries)
+
+			// branches have moved, so decorate with the current refs
+			if current, err := makeHashToNameMap(pa.repo, pa.fullRefs); err == nil {
+				refHashToName = current
+			}
+			tw := getTableWriter(out)
+			appendRows(entries, &tw, refHashToName, pa)
+			fitMessages(tw, out, pa)
+			renderTable(tw, pa)
+		}
+	}
+}
+
+// requireChanges, when --require-changes is set, prints the aggregate diff of
+// HEAD against where it forked from the base, honoring excludes, and returns
+// an error if that diff is empty.
+func requireChanges(out io.Writer, pa *Log) error {
+	if !pa.requireChanges {
+		return nil
+	}
+	if pa.baseCommit == nil {
+		return errors.New("no base to compare changes against")
+	}
+	// diff from the fork point so changes made on the base since then
+	// don't count as changes on this branch
+	ancestor := pa.baseCommit
+	if pa.mergeBase != nil {
+		ancestor = pa.mergeBase
+	}
+	stat, err := getDiffStat(pa.headCommit, ancestor, pa)
+	if err != nil {
+		return fmt.Errorf("error computing changes against base: %w", err)
+	}
+	if stat.files == 0 {
+		fmt.Fprintf(out, "changes against base %s: none\n", prettyHash(pa.baseCommit, pa))
+		return fmt.Errorf("no changes against base %s", shortHash(pa.baseCommit.Hash, pa))
+	}
+	fmt.Fprintf(out, "changes against base %s: %s\n", prettyHash(pa.baseCommit, pa), formatDiffStat(stat))
+	return nil
+}
+
+// logEntry is a commit selected for display by the walk.
+type logEntry struct {
+	commit *object.Commit
+	// ancestor is the commit to diff against, or nil when no diff is shown
+	// unless diffRoot is set
+	ancestor *object.Commit
+	// diffRoot diffs a root commit against the empty tree, with
+	// --diff-against=parent
+	diffRoot    bool
+	bodyContext []string
+	// bytesAdded is the total size of the blobs the commit introduced, only
+	// computed with --sizes
+	bytesAdded int64
+	// stat is the diff against ancestor, if it has been computed
+	stat *diffStat
+	// files break stat down by file, with --name-stat
+	files []fileStat
+	// path, with --follow, is the only file the diff counts, under its name
+	// at the commit
+	path string
+	// merged are the commits a merge brought in, with --fold-merges
+	merged []*object.Commit
+}
+
+// pairWithParents sets each entry to be diffed against its first parent, or
+// against the empty tree for a root commit.
+func pairWithParents(entries []logEntry) error {
+	for i := range entries {
+		if err := pairWithParent(&entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pairWithParent is pairWithParents for a single entry.
+func pairWithParent(entry *logEntry) error {
+	entry.ancestor = nil
+	if entry.commit.NumParents() == 0 {
+		// the whole tree is new in a root commit
+		entry.diffRoot = true
+		return nil
+	}
+	parent, err := entry.commit.Parent(0)
+	if err != nil {
+		return fmt.Errorf("error getting parent of %s: %w", entry.commit.Hash, err)
+	}
+	entry.ancestor = parent
+	return nil
+}
+
+// hasDiff reports whether the entry is shown with a diff.
+func (e logEntry) hasDiff() bool {
+	return e.ancestor != nil || e.diffRoot
+}
+
+// walkLog walks back from HEAD, or the end of --range, collecting up to
+// pa.numberCommits commits that pass the filters, after skipping the first
+// pa.skip of them, pairing each with the ancestor its diff is taken against.
+// reachable reports whether the base is reachable from HEAD. If emit is
+// given, each entry is handed to it as soon as it is found instead of being
+// collected, and paired with its parent by emit itself.
+func walkLog(pa *Log, reachable bool, emit func(logEntry) error) ([]logEntry, error) {
+	entries := make([]logEntry, 0, pa.numberCommits)
+	count := pa.numberCommits
+	skip := pa.skip
+	// with --follow, the name of the followed file as of the commits still
+	// to be visited
+	followName := pa.followPath
+	// a first-parent walk can step over the fork point, e.g. when the base
+	// was merged into the mainline, so its ancestors are all looked for.
+	// They're found once up front rather than walked for every commit.
+	var shared map[plumbing.Hash]bool
+	if reachable && pa.firstParent {
+		var err error
+		shared, err = ancestorSet(pa.mergeBase)
+		if err != nil {
+			return nil, fmt.Errorf("error walking the history of the fork point: %w", err)
+		}
+	}
+	visit := func(commit *object.Commit) error {
+		// commits from the fork point down are shared with the base, so
+		// they have no changes of their own to show against it
+		if reachable && (commit.Hash == pa.mergeBase.Hash || shared[commit.Hash]) {
+			reachable = false
+		}
+		// only reached by the first-parent walk, as everything before it is
+		// excluded too
+		if count == 0 || pa.rangeExclude[commit.Hash] {
+			return storer.ErrStop
+		}
+		// the name is tracked through every commit, whether or not it is
+		// then filtered out, so renames aren't missed
+		followedAs := followName
+		if pa.followPath != "" {
+			change, err := followedChange(commit, followName, pa)
+			if err != nil {
+				return fmt.Errorf("error following %s through %s: %w", followName, commit.Hash, err)
+			}
+			if change == nil {
+				return nil
+			}
+			if change.From.Name != "" {
+				followName = change.From.Name
+			}
+		}
+		if isMerge := commit.NumParents() > 1; (pa.noMerges && isMerge) || (pa.mergesOnly && !isMerge) {
+			return nil
+		}
+		when := commit.Author.When
+		if (!pa.since.IsZero() && when.Before(pa.since)) || (!pa.until.IsZero() && when.After(pa.until)) {
+			return nil
+		}
+		if len(pa.authors) > 0 && !authorMatches(commit, pa.authors) {
+			return nil
+		}
+		if pa.grep != nil {
+			text := prettySubject(commit)
+			if pa.grepAllMatch {
+				text = commit.Message
+			}
+			if !pa.grep.MatchString(text) {
+				return nil
+			}
+		}
+		entry := logEntry{commit: commit}
+		if pa.followPath != "" {
+			entry.path = followedAs
+		}
+		if pa.grepBody != nil {
+			lines, ok := grepBody(commit, pa.grepBody)
+			if !ok {
+				return nil
+			}
+			entry.bodyContext = lines
+		}
+		if len(pa.paths) > 0 && pa.followPath == "" {
+			ok, err := touchesPaths(commit, pa.paths)
+			if err != nil {
+				return fmt.Errorf("error checking paths changed by %s: %w", commit.Hash, err)
+			}
+			if !ok {
+				return nil
+			}
+		}
+		if pa.pickaxe != "" || pa.pickaxeRegex != nil {
+			ok, err := pickaxeMatches(commit, pa)
+			if err != nil {
+				return fmt.Errorf("error searching changes of %s: %w", commit.Hash, err)
+			}
+			if !ok {
+				return nil
+			}
+		}
+		// until the walk passes pa.mergeBase, diff each commit against it:
+		// where HEAD forked from the base, like `git diff base...commit`, so
+		// that when the base has moved on its newer changes don't show up as
+		// deletions. When the base is an ancestor of HEAD the fork point is
+		// the base itself. --snapshot diffs every commit against one commit.
+		if pa.snapshotCommit != nil {
+			entry.ancestor = pa.snapshotCommit
+		} else if reachable {
+			entry.ancestor = pa.mergeBase
+		}
+		// the change limits are checked here rather than after the walk, so
+		// that -n counts only the commits they let through
+		if pa.minChanges > 0 || pa.maxChanges > 0 {
+			if pa.diffAgainst == "parent" {
+				if err := pairWithParent(&entry); err != nil {
+					return err
+				}
+			}
+			// commits without a diff have no changes of their own to count
+			if entry.hasDiff() {
+				if err := statEntry(pa.repo, &entry, pa); err != nil {
+					return fmt.Errorf("error computing the diff of %s: %w", commit.Hash, err)
+				}
+			}
+			if !withinChangeLimits(entry, pa) {
+				return nil
+			}
+		}
+		// like git log --skip, only commits that pass the filters are
+		// skipped, and the fork point check above still sees skipped commits
+		if skip > 0 {
+			skip--
+			return nil
+		}
+		count--
+
+		if pa.foldMerges && commit.NumParents() > 1 {
+			merged, err := mergedCommits(commit)
+			if err != nil {
+				return fmt.Errorf("error finding commits merged by %s: %w", commit.Hash, err)
+			}
+			entry.merged = slices.DeleteFunc(merged, func(c *object.Commit) bool {
+				return pa.rangeExclude[c.Hash]
+			})
+		}
+		if emit != nil {
+			return emit(entry)
+		}
+		entries = append(entries, entry)
+		return nil
+	}
+
+	var err error
+	if pa.firstParent {
+		// with --fold-merges the merged-in commits are folded under their
+		// merge rather than walked
+		err = walkFirstParents(pa.headCommit, visit)
+	} else if pa.all {
+		var tips []*object.Commit
+		tips, err = refTips(pa.repo)
+		if err == nil {
+			err = walkByDate(tips, visit)
+		}
+	} else {
+		// commits in rangeExclude are never visited, nor are their parents
+		err = object.NewCommitPreorderIter(pa.headCommit, pa.rangeExclude, nil).ForEach(visit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error walking commit log: %w", err)
+	}
+
+	if pa.diffAgainst == "parent" {
+		if err := pairWithParents(entries); err != nil {
+			return nil, err
+		}
+	}
+
+	// Entries are newest first, so the previously shown commit in history is
+	// the next entry. This holds regardless of the order rows are rendered
+	// in. The oldest entry falls back to its own parent.
+	if pa.diffAgainst == "prev-shown" {
+		for i := range entries {
+			if i+1 < len(entries) {
+				entries[i].ancestor = entries[i+1].commit
+				continue
+			}
+			entries[i].ancestor = nil
+			if entries[i].commit.NumParents() > 0 {
+				parent, err := entries[i].commit.Parent(0)
+				if err != nil {
+					return nil, fmt.Errorf("error getting parent of %s: %w", entries[i].commit.Hash, err)
+				}
+				entries[i].ancestor = parent
+			}
+		}
+	}
+	if pa.noDiff {
+		dropDiffs(entries)
+	}
+	return entries, nil
+}
+
+// withinChangeLimits reports whether the entry, whose stat has been computed,
+// changes as many lines as --min-changes and --max-changes allow.
+func withinChangeLimits(entry logEntry, pa *Log) bool {
+	changes := 0
+	if entry.stat != nil {
+		changes = entry.stat.insertions + entry.stat.deletions
+	}
+	return changes >= pa.minChanges && (pa.maxChanges == 0 || changes <= pa.maxChanges)
+}
+
+// ancestorSet returns the hashes of commit and all of its ancestors.
+func ancestorSet(commit *object.Commit) (map[plumbing.Hash]bool, error) {
+	set := make(map[plumbing.Hash]bool)
+	err := object.NewCommitPreorderIter(commit, nil, nil).ForEach(func(c *object.Commit) error {
+		set[c.Hash] = true
+		return nil
+	})
+	return set, err
+}
+
+// dropDiffs makes the entries be shown without diffs, so none are computed.
+func dropDiffs(entries []logEntry) {
+	for i := range entries {
+		entries[i].ancestor = nil
+		entries[i].diffRoot = false
+	}
+}
+
+var validDiffStyles = []string{"text", "bar", "net"}
+
+var validFormats = []string{"table", "markdown", "json", "jsonl", "csv", "dot"}
+
+var validAuthorFormats = []string{"name", "email", "initials"}
+
+// printDot renders the walked commits as a Graphviz digraph with an edge from
+// each commit to those of its parents that were also walked. Branches and
+// tags pointing into the set become styled nodes.
+func printDot(out io.Writer, entries []logEntry, pa *Log) error {
+	shown := make(map[plumbing.Hash]bool, len(entries))
+	for _, entry := range entries {
+		shown[entry.commit.Hash] = true
+	}
+
+	fmt.Fprintln(out, "digraph commits {")
+	fmt.Fprintln(out, "\tnode [shape=box, fontname=\"monospace\"];")
+	for _, entry := range entries {
+		c := entry.commit
+		subject := strings.TrimSpace(strings.SplitN(c.Message, "\n", 2)[0])
+		fmt.Fprintf(out, "\t%q [label=%q];\n", c.Hash.String(), shortHash(c.Hash, pa)+"\n"+subject)
+		for _, parent := range c.ParentHashes {
+			if shown[parent] {
+				fmt.Fprintf(out, "\t%q -> %q;\n", c.Hash.String(), parent.String())
+			}
+		}
+	}
+
+	refs, err := pa.repo.References()
+	if err != nil {
+		return fmt.Errorf("error reading references: %w", err)
+	}
+	err = refs.ForEach(func(r *plumbing.Reference) error {
+		if r.Type() != plumbing.HashReference {
+			return nil
+		}
+		target := r.Hash()
+		if r.Name().IsTag() {
+			// annotated tags point at a tag object rather than the commit
+			if commit, err := peelToCommit(pa.repo, target); err == nil {
+				target = commit.Hash
+			}
+		}
+		if !shown[target] {
+			return nil
+		}
+		style := "shape=ellipse, style=filled, fillcolor=lightblue"
+		switch {
+		case r.Name().IsTag():
+			style = "shape=cds, style=filled, fillcolor=gold"
+		case r.Name().IsRemote():
+			style = "shape=ellipse, style=filled, fillcolor=lightpink"
+		case !r.Name().IsBranch():
+			if !pa.fullRefs {
+				return nil
+			}
+			style = "shape=ellipse"
+		}
+		fmt.Fprintf(out, "\t%q [label=%q, %s];\n", r.Name().String(), r.Name().Short(), style)
+		fmt.Fprintf(out, "\t%q -> %q [style=dashed, arrowhead=none];\n", r.Name().String(), target.String())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading references: %w", err)
+	}
+	fmt.Fprintln(out, "}")
+	return nil
+}
+
+var validDiffAgainst = []string{"base", "parent", "prev-shown"}
+
+const (
+	// cochangeTopPairs is how many file pairs --cochange reports
+	cochangeTopPairs = 20
+	// cochangeMaxFiles skips commits touching more files than this, such as
+	// mass reformats, which would swamp the counts with noise and are
+	// quadratic to pair up
+	cochangeMaxFiles = 100
+)
+
+// printCochanges renders the pairs of files most frequently changed together
+// across the walked commits, each commit compared to its first parent.
+func printCochanges(out io.Writer, entries []logEntry, pa *Log) error {
+	type pair struct{ a, b string }
+	counts := make(map[pair]int)
+	for _, entry := range entries {
+		changes, err := firstParentChanges(entry.commit)
+		if err != nil {
+			return fmt.Errorf("error diffing %s: %w", entry.commit.Hash, err)
+		}
+		if len(changes) > cochangeMaxFiles {
+			continue
+		}
+		files := make([]string, 0, len(changes))
+		for _, change := range changes {
+			name := change.To.Name
+			if name == "" {
+				name = change.From.Name
+			}
+			files = append(files, name)
+		}
+		slices.Sort(files)
+		files = slices.Compact(files)
+		for i := range files {
+			for j := i + 1; j < len(files); j++ {
+				counts[pair{files[i], files[j]}]++
+			}
+		}
+	}
+
+	pairs := make([]pair, 0, len(counts))
+	for p := range counts {
+		pairs = append(pairs, p)
+	}
+	slices.SortFunc(pairs, func(x, y pair) int {
+		if counts[x] != counts[y] {
+			return counts[y] - counts[x]
+		}
+		if x.a != y.a {
+			return strings.Compare(x.a, y.a)
+		}
+		return strings.Compare(x.b, y.b)
+	})
+
+	tw := getTableWriter(out)
+	if pa.format == "markdown" {
+		tw.AppendHeader(table.Row{"Commits", "File", "Changed with"})
+	}
+	for _, p := range pairs[:min(len(pairs), cochangeTopPairs)] {
+		tw.AppendRow(table.Row{color.YellowString("%d", counts[p]), p.a, p.b})
+	}
+	renderTable(tw, pa)
+	return nil
+}
+
+// walkFirstParents calls visit for tip and each of its first parents in turn,
+// stopping early if visit returns storer.ErrStop.
+func walkFirstParents(tip *object.Commit, visit func(*object.Commit) error) error {
+	commit := tip
+	for {
+		if err := visit(commit); err != nil {
+			if errors.Is(err, storer.ErrStop) {
+				return nil
+			}
+			return err
+		}
+		if commit.NumParents() == 0 {
+			return nil
+		}
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return err
+		}
+		commit = parent
+	}
+}
+
+// refTips returns the commits HEAD and every branch, remote branch and tag
+// point to, like the starting points of `git log --all`. Tags of trees and
+// blobs are skipped.
+func refTips(repo *git.Repository) ([]*object.Commit, error) {
+	refs, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	var tips []*object.Commit
+	err = refs.ForEach(func(r *plumbing.Reference) error {
+		// symbolic refs like origin/HEAD point at refs seen on their own
+		if r.Type() != plumbing.HashReference {
+			return nil
+		}
+		if r.Name() != plumbing.HEAD && !r.Name().IsBranch() && !r.Name().IsRemote() && !r.Name().IsTag() {
+			return nil
+		}
+		commit, err := peelToCommit(repo, r.Hash())
+		if err != nil {
+			return nil
+		}
+		tips = append(tips, commit)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, err
+	}
+	return append(tips, commit), nil
+}
+
+// walkByDate calls visit for every commit reachable from tips once, newest
+// first by committer date, stopping early if visit returns storer.ErrStop.
+func walkByDate(tips []*object.Commit, visit func(*object.Commit) error) error {
+	seen := make(map[plumbing.Hash]bool)
+	var queue []*object.Commit
+	for _, tip := range tips {
+		if !seen[tip.Hash] {
+			seen[tip.Hash] = true
+			queue = append(queue, tip)
+		}
+	}
+	for len(queue) > 0 {
+		newest := 0
+		for i, c := range queue {
+			if c.Committer.When.After(queue[newest].Committer.When) {
+				newest = i
+			}
+		}
+		commit := queue[newest]
+		queue = slices.Delete(queue, newest, newest+1)
+		if err := visit(commit); err != nil {
+			if errors.Is(err, storer.ErrStop) {
+				return nil
+			}
+			return err
+		}
+		err := commit.Parents().ForEach(func(parent *object.Commit) error {
+			if !seen[parent.Hash] {
+				seen[parent.Hash] = true
+				queue = append(queue, parent)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergedCommits returns the commits a merge brought in: those reachable from
+// its second parent but not its first, newest first.
+func mergedCommits(merge *object.Commit) ([]*object.Commit, error) {
+	first, err := merge.Parent(0)
+	if err != nil {
+		return nil, err
+	}
+	second, err := merge.Parent(1)
+	if err != nil {
+		return nil, err
+	}
+	mbCommits, err := first.MergeBase(second)
+	if err != nil {
+		return nil, err
+	}
+	return commitsUntil(second, mbCommits)
+}
+
+// jsonWeek is the machine-readable form of a --by-week row.
+type jsonWeek struct {
+	Week         string `json:"week"`
+	Commits      int    `json:"commits"`
+	FilesChanged int    `json:"filesChanged"`
+	Insertions   int    `json:"insertions"`
+	Deletions    int    `json:"deletions"`
+	BinaryFiles  int    `json:"binaryFiles"`
+}
+
+// printWeeks renders the walked commits aggregated into ISO-week buckets,
+// oldest week first, with each week's commit count and churn. Churn is
+// measured per commit against its first parent.
+func printWeeks(out io.Writer, entries []logEntry, pa *Log) error {
+	type week struct {
+		key     string
+		commits int
+		stat    diffStat
+	}
+	weeks := make(map[string]*week)
+	for _, entry := range entries {
+		year, wk := entry.commit.Author.When.ISOWeek()
+		key := fmt.Sprintf("%d-W%02d", year, wk)
+		if _, ok := weeks[key]; !ok {
+			weeks[key] = &week{key: key}
+		}
+		var parent *object.Commit
+		if entry.commit.NumParents() > 0 {
+			p, err := entry.commit.Parent(0)
+			if err != nil {
+				return fmt.Errorf("error getting parent of %s: %w", entry.commit.Hash, err)
+			}
+			parent = p
+		}
+		stat, err := getDiffStat(entry.commit, parent, pa)
+		if err != nil {
+			return fmt.Errorf("error computing churn for %s: %w", entry.commit.Hash, err)
+		}
+		weeks[key].commits++
+		weeks[key].stat = weeks[key].stat.Add(stat)
+	}
+
+	keys := make([]string, 0, len(weeks))
+	for key := range weeks {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	if pa.format == "json" {
+		rows := make([]jsonWeek, 0, len(keys))
+		for _, key := range keys {
+			w := weeks[key]
+			rows = append(rows, jsonWeek{
+				Week:         w.key,
+				Commits:      w.commits,
+				FilesChanged: w.stat.files,
+				Insertions:   w.stat.insertions,
+				Deletions:    w.stat.deletions,
+				BinaryFiles:  w.stat.binaries,
+			})
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rows); err != nil {
+			return fmt.Errorf("error writing json: %w", err)
+		}
+		return nil
+	}
+
+	tw := getTableWriter(out)
+	if pa.format == "markdown" {
+		tw.AppendHeader(table.Row{"Week", "Commits", "Changes"})
+	}
+	for _, key := range keys {
+		w := weeks[key]
+		tw.AppendRow(table.Row{color.YellowString(w.key), w.commits, diffText(w.stat, pa)})
+	}
+	renderTable(tw, pa)
+	return nil
+}
+
+var validModes = []string{"base", "branch", "commit"}
+
+// ErrNoCommits is returned when HEAD is unborn because the repository has no
+// commits at all, e.g. right after git init.
+var ErrNoCommits = errors.New("repository has no commits yet")
+
+// checkHead makes sure HEAD resolves to a commit, distinguishing a repository
+// with no commits yet from one whose HEAD names a branch that doesn't exist,
+// since go-git reports both as a bare "reference not found".
+func checkHead(repo *git.Repository) error {
+	head, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil {
+		return fmt.Errorf("error reading HEAD: %w", err)
+	}
+	if head.Type() != plumbing.SymbolicReference {
+		return nil
+	}
+	_, err = repo.Reference(head.Target(), true)
+	if err == nil || !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return err
+	}
+
+	branches, err := repo.Branches()
+	if err != nil {
+		return err
+	}
+	hasBranches := false
+	branches.ForEach(func(*plumbing.Reference) error {
+		hasBranches = true
+		return storer.ErrStop
+	})
+	if !hasBranches {
+		return fmt.Errorf("%w (HEAD points to unborn branch %s)", ErrNoCommits, head.Target().Short())
+	}
+	return fmt.Errorf("HEAD points to branch %s, which does not exist; check out an existing branch", head.Target().Short())
+}
+
+// resolveRange resolves an A..B range to its two endpoints. As in git, an
+// omitted endpoint means HEAD.
+func resolveRange(repo *git.Repository, spec string) (*object.Commit, *object.Commit, error) {
+	from, to, ok := strings.Cut(spec, "..")
+	if !ok {
+		return nil, nil, errors.New("must be of the form A..B")
+	}
+	if strings.HasPrefix(to, ".") {
+		return nil, nil, errors.New("symmetric differences (A...B) aren't supported")
+	}
+	endpoints := make([]*object.Commit, 0, 2)
+	for _, rev := range []string{from, to} {
+		if rev == "" {
+			rev = "HEAD"
+		}
+		hash, err := resolveRevision(repo, rev)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", rev, err)
+		}
+		commit, err := peelToCommit(repo, hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", rev, err)
+		}
+		endpoints = append(endpoints, commit)
+	}
+	return endpoints[0], endpoints[1], nil
+}
+
+// resolveRevision resolves rev to the object it names, to be peeled with
+// peelToCommit. go-git refuses to resolve a tag whose target is another tag,
+// so tags that it fails on are looked up by name instead.
+func resolveRevision(repo *git.Repository, rev string) (plumbing.Hash, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err == nil {
+		return *hash, nil
+	}
+	if tag, tagErr := repo.Tag(rev); tagErr == nil {
+		return tag.Hash(), nil
+	}
+	return plumbing.ZeroHash, err
+}
+
+// peelToCommit returns the commit at hash, following annotated tags (and tags
+// of tags) to the commit they point at. A revision like an annotated tag's
+// object id resolves to the tag rather than its commit.
+func peelToCommit(repo *git.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	obj, err := repo.Object(plumbing.AnyObject, hash)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch o := obj.(type) {
+		case *object.Commit:
+			return o, nil
+		case *object.Tag:
+			obj, err = o.Object()
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("%s is a %s, not a commit", hash, obj.Type())
+		}
+	}
+}
+
+// errNoBaseBranch is returned by getBaseBranch when no candidate exists.
+var errNoBaseBranch = errors.New("unable to find a base branch")
+
+// defaultBaseCandidates are the branch names tried for the base when neither
+// --base-candidates nor the repository say otherwise.
+var defaultBaseCandidates = []string{"main", "master"}
+
+// getBaseBranch finds the branch to compare against when no base was given.
+// It tries the candidates from --base-candidates, then the remote's default
+// branch via origin/HEAD, then init.defaultBranch, then main and master.
+// Each name is looked up as a local branch and then as a branch of origin,
+// though origin's is taken when the local branch is behind it, as it is when
+// it hasn't been pulled, or whenever remote is set, as with --remote-base.
+func getBaseBranch(repo *git.Repository, candidates []string, remote bool) (*plumbing.Reference, error) {
+	var names []string
+	add := func(name string) {
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	for _, name := range candidates {
+		add(name)
+	}
+	// origin/HEAD is a symref to the remote's default branch
+	if ref, err := repo.Reference(plumbing.NewRemoteHEADReferenceName("origin"), false); err == nil && ref.Type() == plumbing.SymbolicReference {
+		add(strings.TrimPrefix(ref.Target().String(), "refs/remotes/origin/"))
+	}
+	defaultBranch, err := gitConfigOption(repo, "init", "defaultBranch")
+	if err != nil {
+		return nil, err
+	}
+	add(defaultBranch)
+	for _, name := range defaultBaseCandidates {
+		add(name)
+	}
+
+	for _, name := range names {
+		local, localErr := repo.Reference(plumbing.NewBranchReferenceName(name), true)
+		upstream, upstreamErr := repo.Reference(plumbing.NewRemoteReferenceName("origin", name), true)
+		switch {
+		case localErr != nil && upstreamErr != nil:
+			continue
+		case upstreamErr != nil:
+			return local, nil
+		case localErr != nil || remote:
+			return upstream, nil
+		}
+		stale, err := isBehind(repo, local.Hash(), upstream.Hash())
+		if err != nil {
+			return nil, err
+		}
+		if stale {
+			return upstream, nil
+		}
+		return local, nil
+	}
+	return nil, fmt.Errorf("%w among %s", errNoBaseBranch, strings.Join(names, ", "))
+}
+
+// isBehind reports whether the commit at hash is a strict ancestor of the one
+// at other, i.e. other has moved on from it.
+func isBehind(repo *git.Repository, hash, other plumbing.Hash) (bool, error) {
+	if hash == other {
+		return false, nil
+	}
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return false, err
+	}
+	otherCommit, err := repo.CommitObject(other)
+	if err != nil {
+		return false, err
+	}
+	return commit.IsAncestor(otherCommit)
+}
+
+// makeHashToNameMap maps commit hashes to the names of the refs that point at
+// them, with HEAD listed first on the commit it resolves to. Unless fullRefs
+// is set only branches, remote branches and tags are kept: repos with tens of
+// thousands of CI-created refs (e.g. refs/pull/*) otherwise spend most of
+// their time and memory building decorations that are never displayed. The
+// map isn't built lazily: every kept ref is read up front, in one pass, even
+// when only a few commits end up shown.
+func makeHashToNameMap(repo *git.Repository, fullRefs bool) (map[string][]plumbing.ReferenceName, error) {
+	refs, err := repo.References()
+	if err != nil {
+		return nil, err
+	}
+	refHashToName := make(map[string][]plumbing.ReferenceName)
+	if head, err := repo.Head(); err == nil {
+		refHashToName[head.Hash().String()] = []plumbing.ReferenceName{plumbing.HEAD}
+	}
+	refs.ForEach(func(r *plumbing.Reference) error {
+		if r.Type() != plumbing.HashReference || r.Name() == plumbing.HEAD {
+			return nil
+		}
+		if !fullRefs && !r.Name().IsBranch() && !r.Name().IsRemote() && !r.Name().IsTag() {
+			return nil
+		}
+		refHash := r.Hash().String()
+		refHashToName[refHash] = append(refHashToName[refHash], r.Name())
+		return nil
+	})
+	return refHashToName, nil
+}
+
+// shortRefNames returns the short names of refs, leaving out HEAD, which is
+// only a decoration.
+func shortRefNames(refs []plumbing.ReferenceName) []string {
+	names := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if ref != plumbing.HEAD {
+			names = append(names, ref.Short())
+		}
+	}
+	return names
+}
+
+// refColor picks the color of a ref decoration the way git log --decorate
+// does: HEAD in bold cyan, local branches in bold green, remote branches in
+// bold red and tags in bold yellow. Anything else, shown with --full-refs, is
+// magenta.
+func refColor(ref plumbing.ReferenceName) *color.Color {
+	switch {
+	case ref == plumbing.HEAD:
+		return color.New(color.FgCyan, color.Bold)
+	case ref.IsBranch():
+		return color.New(color.FgGreen, color.Bold)
+	case ref.IsRemote():
+		return color.New(color.FgRed, color.Bold)
+	case ref.IsTag():
+		return color.New(color.FgYellow, color.Bold)
+	}
+	return color.New(color.FgMagenta)
+}
+
+func isBaseReachableFromHead(args *Log) bool {
+	return args.mergeBase != nil
+}
+
+// gitConfigOption returns the value of section.key, reading the repository
+// config first and falling back to the global and then system config, so the
+// most specific setting wins as it does in git. It returns "" when unset.
+func gitConfigOption(repo *git.Repository, section, key string) (string, error) {
+	value, _, err := LookupGitConfig(repo, section, key)
+	return value, err
+}
+
+// LookupGitConfig returns the value of section.key from the repository
+// config, falling back to the global and then system config as git does, and
+// whether it is set at all, for options where being set to nothing means
+// something, like core.pager.
+func LookupGitConfig(repo *git.Repository, section, key string) (string, bool, error) {
+	local, err := repo.Config()
+	if err != nil {
+		return "", false, err
+	}
+	if local.Raw.HasSection(section) && local.Raw.Section(section).HasOption(key) {
+		return local.Raw.Section(section).Option(key), true, nil
+	}
+	for _, scope := range []config.Scope{config.GlobalScope, config.SystemScope} {
+		cfg, err := config.LoadConfig(scope)
+		if err != nil {
+			return "", false, err
+		}
+		if cfg.Raw.HasSection(section) && cfg.Raw.Section(section).HasOption(key) {
+			return cfg.Raw.Section(section).Option(key), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+var validColorModes = []string{"auto", "always", "never"}
+
+// configureColor decides whether output is colored, which every pretty*
+// helper follows through color.NoColor. An explicit --color=always or never
+// wins; in auto mode NO_COLOR, then color.ui from git config, then whether
+// stdout is a terminal decide. Machine-readable formats are never colored
+// since escape codes would corrupt them.
+func configureColor(repo *git.Repository, mode string, format string) error {
+	switch {
+	case format == "json" || format == "jsonl" || format == "csv" || format == "markdown":
+		color.NoColor = true
+	case mode == "always":
+		// the color package consults NO_COLOR itself whenever a color is
+		// built, so the explicit flag can only win if it's cleared
+		os.Unsetenv("NO_COLOR")
+		color.NoColor = false
+	case mode == "never":
+		color.NoColor = true
+	case os.Getenv("NO_COLOR") != "":
+		color.NoColor = true
+	default:
+		ui, err := gitConfigOption(repo, "color", "ui")
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(ui) {
+		case "never", "false":
+			color.NoColor = true
+		case "always", "true":
+			color.NoColor = false
+		default:
+			color.NoColor = !StdoutIsTerminal()
+		}
+	}
+	return nil
+}
+
+// StdoutIsTerminal reports whether stdout is a terminal able to show colors,
+// which a dumb one isn't.
+func StdoutIsTerminal() bool {
+	fd := os.Stdout.Fd()
+	return os.Getenv("TERM") != "dumb" && (isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd))
+}
+
+// defaultAbbrev is how many hex digits of a hash are shown by default, and
+// minAbbrev the fewest that can be asked for.
+const (
+	defaultAbbrev = 7
+	minAbbrev     = 4
+)
+
+// uniqueAbbrev returns how many hex digits tell all of hashes apart, and
+// never fewer than defaultAbbrev, as shorter hashes would likely be ambiguous
+// among the rest of the repository's objects.
+func uniqueAbbrev(hashes []plumbing.Hash) int {
+	hexes := make([]string, 0, len(hashes))
+	for _, hash := range hashes {
+		hexes = append(hexes, hash.String())
+	}
+	slices.Sort(hexes)
+	hexes = slices.Compact(hexes)
+	abbrev := defaultAbbrev
+	for i := 1; i < len(hexes); i++ {
+		common := 0
+		for common < len(hexes[i]) && hexes[i][common] == hexes[i-1][common] {
+			common++
+		}
+		abbrev = max(abbrev, min(common+1, len(hexes[i])))
+	}
+	return abbrev
+}
+
+// gitLogDates maps log.date values from git config to the equivalent --date
+// mode. Values with no equivalent are ignored.
+var gitLogDates = map[string]string{
+	"relative": "relative",
+	"human":    "relative-human",
+	"iso":      "iso",
+	"iso8601":  "iso",
+	"short":    "short",
+}
+
+// applyGitLogConfig fills in the date format and hash abbreviation from
+// log.date and log.abbrevCommit when they weren't given as flags, so the tool
+// follows the same preferences as git log.
+func applyGitLogConfig(repo *git.Repository, a *Options) error {
+	if a.Date == "" {
+		logDate, err := gitConfigOption(repo, "log", "date")
+		if err != nil {
+			return err
+		}
+		a.Date = "relative"
+		if mode, ok := gitLogDates[logDate]; ok {
+			a.Date = mode
+		}
+	}
+	if a.abbrevLen == 0 {
+		a.abbrevLen = defaultAbbrev
+		value, err := gitConfigOption(repo, "log", "abbrevCommit")
+		if err != nil {
+			return err
+		}
+		// log.abbrevCommit = false asks for whole hashes
+		if abbrevCommit, err := strconv.ParseBool(value); err == nil && !abbrevCommit {
+			a.abbrevLen = len(plumbing.ZeroHash.String())
+		}
+	}
+	return nil
+}
+
+// getTrackingRef returns the remote-tracking reference for the given local
+// branch, preferring its configured upstream and falling back to the branch
+// of the same name on origin. It returns nil if there is no such ref.
+func getTrackingRef(repo *git.Repository, branch plumbing.ReferenceName) (*plumbing.Reference, error) {
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	candidate := plumbing.NewRemoteReferenceName("origin", branch.Short())
+	if b, ok := cfg.Branches[branch.Short()]; ok && b.Remote != "" && b.Merge != "" {
+		candidate = plumbing.NewRemoteReferenceName(b.Remote, b.Merge.Short())
+	}
+	ref, err := repo.Reference(candidate, true)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	}
+	return ref, err
+}
+
+// commitsUntil returns the commits reachable from tip without descending past
+// any of the stop commits, which are themselves excluded.
+func commitsUntil(tip *object.Commit, stop []*object.Commit) ([]*object.Commit, error) {
+	ignore := make([]plumbing.Hash, 0, len(stop))
+	for _, c := range stop {
+		ignore = append(ignore, c.Hash)
+	}
+	commits := make([]*object.Commit, 0)
+	err := object.NewCommitPreorderIter(tip, nil, ignore).ForEach(func(c *object.Commit) error {
+		commits = append(commits, c)
+		return nil
+	})
+	return commits, err
+}
+
+// latestReleaseTag finds the tag matching pattern that is closest to HEAD in
+// commit time order, like git describe, returning its name and commit. It
+// returns a nil commit when no matching tag is reachable.
+func latestReleaseTag(pa *Log, pattern string) (string, *object.Commit, error) {
+	tagsByCommit := make(map[plumbing.Hash][]string)
+	tags, err := pa.repo.Tags()
+	if err != nil {
+		return "", nil, err
+	}
+	err = tags.ForEach(func(r *plumbing.Reference) error {
+		if ok, _ := path.Match(pattern, r.Name().Short()); !ok {
+			return nil
+		}
+		commit, err := peelToCommit(pa.repo, r.Hash())
+		if err != nil {
+			// tags of trees or blobs can't be releases of the history
+			return nil
+		}
+		tagsByCommit[commit.Hash] = append(tagsByCommit[commit.Hash], r.Name().Short())
+		return nil
+	})
+	if err != nil || len(tagsByCommit) == 0 {
+		return "", nil, err
+	}
+
+	log, err := pa.repo.Log(&git.LogOptions{From: pa.headCommit.Hash, Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return "", nil, err
+	}
+	var name string
+	var tagged *object.Commit
+	err = log.ForEach(func(c *object.Commit) error {
+		if names, ok := tagsByCommit[c.Hash]; ok {
+			slices.Sort(names)
+			name, tagged = names[len(names)-1], c
+			return storer.ErrStop
+		}
+		return nil
+	})
+	return name, tagged, err
+}
+
+// printSinceRelease writes how many commits HEAD has on top of the latest
+// release tag, or how many commits there are in total when there's none.
+func printSinceRelease(out io.Writer, pa *Log) error {
+	name, tagged, err := latestReleaseTag(pa, pa.releasePattern)
+	if err != nil {
+		return err
+	}
+	stop := []*object.Commit{}
+	if tagged != nil {
+		stop = append(stop, tagged)
+	}
+	commits, err := commitsUntil(pa.headCommit, stop)
+	if err != nil {
+		return err
+	}
+	if tagged == nil {
+		fmt.Fprintf(out, "%s commits, no release tag found\n", color.YellowString("%d", len(commits)))
+		return nil
+	}
+	fmt.Fprintf(out, "%s commits since %s\n", color.YellowString("%d", len(commits)), color.RedString(name))
+	return nil
+}
+
+// printStatus writes how many commits HEAD is ahead of and behind the base,
+// counted from the merge bases of the two like `git rev-list --left-right
+// --count`.
+func printStatus(out io.Writer, pa *Log, refHashToName map[string][]plumbing.ReferenceName) error {
+	if pa.baseCommit == nil {
+		fmt.Fprintln(out, "HEAD has no base to compare against")
+		return nil
+	}
+	// the fork point found when the arguments were parsed
+	var stop []*object.Commit
+	if pa.mergeBase != nil {
+		stop = []*object.Commit{pa.mergeBase}
+	}
+	ahead, err := commitsUntil(pa.headCommit, stop)
+	if err != nil {
+		return err
+	}
+	behind, err := commitsUntil(pa.baseCommit, stop)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "HEAD is %s, %s %s",
+		color.GreenString("%d ahead", len(ahead)),
+		color.RedString("%d behind", len(behind)),
+		prettyBase(pa, refHashToName),
+	)
+	switch {
+	case pa.mergeBase == nil:
+		fmt.Fprint(out, ", sharing no history")
+	case len(ahead) == 0 && len(behind) == 0:
+		fmt.Fprint(out, ", up to date")
+	case len(ahead) == 0:
+		fmt.Fprint(out, ", fully merged")
+	}
+	fmt.Fprintln(out)
+	return nil
+}
+
+// printShownCount writes how many of the commits HEAD has since it forked
+// from the base are among the entries shown, or of all its commits when there
+// is no base or they share no history, so it's clear whether there's more to
+// page through with --skip.
+func printShownCount(out io.Writer, entries []logEntry, pa *Log) error {
+	var stop []*object.Commit
+	since := ""
+	if pa.mergeBase != nil {
+		stop = append(stop, pa.mergeBase)
+		since = " since base"
+	}
+	commits, err := commitsUntil(pa.headCommit, stop)
+	if err != nil {
+		return err
+	}
+	counted := make(map[plumbing.Hash]bool, len(commits))
+	for _, c := range commits {
+		counted[c.Hash] = true
+	}
+	shown := 0
+	for _, entry := range entries {
+		if counted[entry.commit.Hash] {
+			shown++
+		}
+	}
+	fmt.Fprintf(out, "showing %s of %s commits%s\n", color.CyanString("%d", shown), color.CyanString("%d", len(commits)), since)
+	return nil
+}
+
+// printDivergence writes a summary of how HEAD's branch differs from its
+// remote-tracking branch. It writes nothing when HEAD is detached, there is
+// no remote-tracking branch, or the two agree.
+func printDivergence(out io.Writer, pa *Log) error {
+	repo := pa.repo
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	if !head.Name().IsBranch() {
+		return nil
+	}
+	tracking, err := getTrackingRef(repo, head.Name())
+	if err != nil || tracking == nil || tracking.Hash() == head.Hash() {
+		return err
+	}
+
+	local, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	remote, err := repo.CommitObject(tracking.Hash())
+	if err != nil {
+		return err
+	}
+	mbCommits, err := local.MergeBase(remote)
+	if err != nil {
+		return err
+	}
+	added, err := commitsUntil(local, mbCommits)
+	if err != nil {
+		return err
+	}
+	removed, err := commitsUntil(remote, mbCommits)
+	if err != nil {
+		return err
+	}
+
+	// the branches have only diverged when each has commits the other lacks
+	branch, remoteBranch := color.RedString(head.Name().Short()), color.RedString(tracking.Name().Short())
+	switch {
+	case len(removed) == 0:
+		fmt.Fprintf(out, "%s %s is ahead of %s: %s\n",
+			color.New(color.FgGreen).Add(color.Bold).Sprint("ahead:"),
+			branch, remoteBranch,
+			color.GreenString("%d(+) local only", len(added)),
+		)
+	case len(added) == 0:
+		fmt.Fprintf(out, "%s %s is behind %s: %s\n",
+			color.New(color.FgYellow).Add(color.Bold).Sprint("behind:"),
+			branch, remoteBranch,
+			color.RedString("%d(-) remote only", len(removed)),
+		)
+	default:
+		fmt.Fprintf(out, "%s %s differs from %s: %s, %s\n",
+			color.New(color.FgRed).Add(color.Bold).Sprint("diverged:"),
+			branch, remoteBranch,
+			color.GreenString("%d(+) local only", len(added)),
+			color.RedString("%d(-) remote only", len(removed)),
+		)
+	}
+	for _, c := range removed {
+		fmt.Fprintf(out, "  - %s %s\n", prettyHash(c, pa), strings.TrimSpace(strings.SplitN(c.Message, "\n", 2)[0]))
+	}
+
+	// the reflog records when the tracking ref was rewritten rather than
+	// fast-forwarded, e.g. by a fetch after someone else force-pushed
+	entries, err := readReflog(repo, tracking.Name())
+	if err != nil {
+		return err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if strings.Contains(entries[i].message, "forced-update") {
+			fmt.Fprintf(out, "  %s was force-updated from %s %s\n",
+				tracking.Name().Short(),
+				color.YellowString(shortHash(entries[i].oldHash, pa)),
+				color.GreenString(gotime.TimeAgo(entries[i].when)),
+			)
+			break
+		}
+	}
+	return nil
+}
+
+type reflogEntry struct {
+	oldHash plumbing.Hash
+	newHash plumbing.Hash
+	when    time.Time
+	message string
+}
+
+// readReflog parses the reflog for the given reference, oldest entry first.
+// Repositories not backed by a filesystem, and refs without a reflog, yield
+// no entries.
+func readReflog(repo *git.Repository, name plumbing.ReferenceName) ([]reflogEntry, error) {
+	storage, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return nil, nil
+	}
+	f, err := storage.Filesystem().Open(path.Join("logs", name.String()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make([]reflogEntry, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// <old> <new> <name> <<email>> <unix time> <tz>\t<message>
+		line, message, _ := strings.Cut(scanner.Text(), "\t")
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		sig := object.Signature{}
+		sig.Decode([]byte(strings.Join(fields[2:], " ")))
+		entries = append(entries, reflogEntry{
+			oldHash: plumbing.NewHash(fields[0]),
+			newHash: plumbing.NewHash(fields[1]),
+			when:    sig.When,
+			message: message,
+		})
+	}
+	return entries, scanner.Err()
+}
+
+// printReflog renders HEAD's reflog, newest entry first, in place of the
+// log. Each move of HEAD is diffed from where it was before, so a rebase or
+// reset shows how much it changed. Entries whose commits have since been
+// pruned are listed without a diff. It returns how many entries were shown.
+func printReflog(out io.Writer, pa *Log) (int, error) {
+	entries, err := readReflog(pa.repo, plumbing.HEAD)
+	if err != nil {
+		return 0, fmt.Errorf("error reading the reflog: %w", err)
+	}
+	slices.Reverse(entries)
+
+	type reflogRow struct {
+		selector string
+		entry    reflogEntry
+		stat     *diffStat
+	}
+	rows := make([]reflogRow, 0, pa.numberCommits)
+	maxChanges := 0
+	for i := pa.skip; i < len(entries) && len(rows) < pa.numberCommits; i++ {
+		row := reflogRow{selector: fmt.Sprintf("HEAD@{%d}", i), entry: entries[i]}
+		if !pa.noDiff && !pa.quiet && !row.entry.oldHash.IsZero() {
+			commit, err := pa.repo.CommitObject(row.entry.newHash)
+			if err == nil {
+				ancestor, err := pa.repo.CommitObject(row.entry.oldHash)
+				if err == nil {
+					stat, err := getDiffStat(commit, ancestor, pa)
+					if err != nil {
+						return 0, fmt.Errorf("error diffing %s: %w", row.selector, err)
+					}
+					row.stat = &stat
+					maxChanges = max(maxChanges, stat.insertions+stat.deletions)
+				}
+			}
+		}
+		rows = append(rows, row)
+	}
+	if pa.quiet {
+		return len(rows), nil
+	}
+	if pa.autoAbbrev {
+		hashes := make([]plumbing.Hash, 0, len(rows))
+		for _, row := range rows {
+			hashes = append(hashes, row.entry.newHash)
+		}
+		pa.abbrev = uniqueAbbrev(hashes)
+	}
+
+	tw := getTableWriter(out)
+	if pa.format == "markdown" {
+		header := table.Row{"Hash", "Selector", "When"}
+		if !pa.noDiff {
+			header = append(header, "Changes")
+		}
+		tw.AppendHeader(append(header, "Message"))
+	}
+	for _, row := range rows {
+		tr := table.Row{
+			color.YellowString(shortHash(row.entry.newHash, pa)),
+			color.New(color.Faint).Sprint(row.selector),
+			color.GreenString(formatDate(row.entry.when, pa)),
+		}
+		if !pa.noDiff {
+			var diff string
+			if row.stat != nil {
+				diff = prettyDiff(*row.stat, maxChanges, pa)
+			}
+			tr = append(tr, diff)
+		}
+		tw.AppendRow(append(tr, row.entry.message))
+	}
+	renderTable(tw, pa)
+	return len(rows), nil
+}
+
+// renderTable writes out the table, as tab-separated values in plain mode.
+func renderTable(tw table.Writer, pa *Log) {
+	if pa.format == "markdown" {
+		tw.RenderMarkdown()
+		return
+	}
+	if t, ok := tw.(*tsvTable); ok && pa.plain {
+		t.renderTSV()
+		return
+	}
+	tw.Render()
+}
+
+// tsvTable is a table.Writer that keeps the cells appended to it, so that
+// plain mode can write them out as they are. go-pretty's own TSV quotes any
+// cell with a double quote in it, the way CSV does, which cut and awk don't
+// undo.
+type tsvTable struct {
+	table.Writer
+	out                    io.Writer
+	headers, rows, footers []table.Row
+}
+
+func (t *tsvTable) AppendHeader(row table.Row, configs ...table.RowConfig) {
+	t.headers = append(t.headers, row)
+	t.Writer.AppendHeader(row, configs...)
+}
+
+func (t *tsvTable) AppendRow(row table.Row, configs ...table.RowConfig) {
+	t.rows = append(t.rows, row)
+	t.Writer.AppendRow(row, configs...)
+}
+
+func (t *tsvTable) AppendFooter(row table.Row, configs ...table.RowConfig) {
+	t.footers = append(t.footers, row)
+	t.Writer.AppendFooter(row, configs...)
+}
+
+func (t *tsvTable) SetOutputMirror(out io.Writer) {
+	t.out = out
+	t.Writer.SetOutputMirror(out)
+}
+
+// renderTSV writes each row as its cells separated by single tabs. The
+// further lines of a multi-line cell, such as a --full body, go on lines of
+// their own under the column they belong to, and tabs within cells become
+// spaces, so every line splits into the same fields.
+func (t *tsvTable) renderTSV() {
+	if t.out == nil {
+		return
+	}
+	rows := slices.Concat(t.headers, t.rows, t.footers)
+	columns := 0
+	for _, row := range rows {
+		columns = max(columns, len(row))
+	}
+	for _, row := range rows {
+		cells := make([][]string, columns)
+		lines := 1
+		for i, cell := range row {
+			cells[i] = strings.Split(strings.ReplaceAll(fmt.Sprint(cell), "\t", " "), "\n")
+			lines = max(lines, len(cells[i]))
+		}
+		for line := range lines {
+			fields := make([]string, columns)
+			for i, cell := range cells {
+				if line < len(cell) {
+					fields[i] = cell[line]
+				}
+			}
+			fmt.Fprintln(t.out, strings.Join(fields, "\t"))
+		}
+	}
+}
+
+// minMessageWidth is the narrowest messages are truncated to, however little
+// room the other columns leave.
+const minMessageWidth = 20
+
+// fitMessages truncates the messages of a log table written to out so its
+// rows fit in pa.width columns, if set. Messages are cut from the end, so the
+// ref decorations in front of the subject are kept.
+func fitMessages(tw table.Writer, out io.Writer, pa *Log) {
+	// markdown is for pasting elsewhere, not for the terminal, and plain
+	// rows are written out whole
+	if pa.width == 0 || pa.format == "markdown" || pa.plain {
+		return
+	}
+	messageColumn := slices.Index(pa.columns, "message") + 1
+	if messageColumn == 0 {
+		return
+	}
+	// render the table without the message column to see how much room the
+	// others take up
+	tw.SetOutputMirror(nil)
+	tw.SetColumnConfigs([]table.ColumnConfig{{Number: messageColumn, Hidden: true}})
+	others := 0
+	for _, line := range strings.Split(tw.Render(), "\n") {
+		others = max(others, text.RuneWidthWithoutEscSequences(line))
+	}
+	tw.SetOutputMirror(out)
+
+	// leave room for the padding on either side of the message
+	budget := max(pa.width-others-2, minMessageWidth)
+	tw.SetColumnConfigs([]table.ColumnConfig{{
+		Number:   messageColumn,
+		WidthMax: budget,
+		WidthMaxEnforcer: func(message string, maxLen int) string {
+			lines := strings.Split(message, "\n")
+			for i, line := range lines {
+				if text.RuneWidthWithoutEscSequences(line) > maxLen {
+					lines[i] = text.Trim(line, maxLen-1) + "…"
+				}
+			}
+			return strings.Join(lines, "\n")
+		},
+	}})
+}
+
+func getTableWriter(out io.Writer) table.Writer {
+	t := &tsvTable{Writer: table.NewWriter()}
+	t.SetOutputMirror(out)
+	t.Style().Options.DrawBorder = false
+	t.Style().Options.SeparateColumns = false
+	t.Style().Options.SeparateFooter = false
+	t.Style().Options.SeparateHeader = false
+	t.Style().Options.SeparateRows = false
+	t.Style().Format.Footer = text.FormatDefault
+	return t
+}
+
+func printCommit(entry logEntry, tw *table.Writer, refHashToName map[string][]plumbing.ReferenceName, pa *Log) {
+	appendCommitRow(entry, "", tw, refHashToName, pa)
+}
+
+func printCommitWithDiff(entry logEntry, maxChanges int, tw *table.Writer, refHashToName map[string][]plumbing.ReferenceName, pa *Log) {
+	var diff string
+	if entry.stat != nil {
+		diff = prettyDiff(*entry.stat, maxChanges, pa)
+	}
+	appendCommitRow(entry, diff, tw, refHashToName, pa)
+}
+
+func appendCommitRow(entry logEntry, diff string, tw *table.Writer, refHashToName map[string][]plumbing.ReferenceName, pa *Log) {
+	commit := entry.commit
+	row := make(table.Row, 0, len(pa.columns))
+	for _, column := range pa.columns {
+		switch column {
+		case "hash":
+			row = append(row, prettyHash(commit, pa))
+		case "signature":
+			row = append(row, prettySignature(commit, pa))
+		case "date":
+			row = append(row, prettyRelativeTime(commit, pa))
+		case "committer-date":
+			row = append(row, prettyCommitterTime(commit, pa))
+		case "author":
+			row = append(row, prettyAuthor(commit, pa))
+		case "signoff":
+			row = append(row, prettySignoff(commit))
+		case "diff":
+			row = append(row, diff)
+		case "size":
+			row = append(row, color.CyanString(humanBytes(entry.bytesAdded)))
+		case "message":
+			message := prettyMessage(commit, refHashToName, pa)
+			if len(entry.merged) > 0 {
+				message += color.New(color.Faint).Sprintf(" (+%d merged)", len(entry.merged))
+			}
+			row = append(row, message)
+		}
+	}
+	if isMine(commit, pa) {
+		highlightRow(row)
+	}
+	(*tw).AppendRow(row)
+}
+
+// resolveMe sets who --highlight-me highlights: the email given with --me,
+// or else user.email and user.name from git config.
+func resolveMe(pa *Log, me string) error {
+	if me != "" {
+		pa.meEmail = me
+		return nil
+	}
+	email, err := gitConfigOption(pa.repo, "user", "email")
+	if err != nil {
+		return fmt.Errorf("error reading user.email: %w", err)
+	}
+	name, err := gitConfigOption(pa.repo, "user", "name")
+	if err != nil {
+		return fmt.Errorf("error reading user.name: %w", err)
+	}
+	if email == "" && name == "" {
+		return errors.New("--highlight-me needs user.email or user.name set in git config, or an email given with --me")
+	}
+	pa.meEmail, pa.meName = email, name
+	return nil
+}
+
+// isMine reports whether the commit was authored by the user --highlight-me
+// highlights. Emails are compared ignoring case, as with .mailmap.
+func isMine(commit *object.Commit, pa *Log) bool {
+	switch {
+	case pa.meEmail != "":
+		return strings.EqualFold(commit.Author.Email, pa.meEmail)
+	case pa.meName != "":
+		return commit.Author.Name == pa.meName
+	}
+	return false
+}
+
+// resetRE matches the escape codes that end the cells' colors, which reset
+// every attribute and so would end a highlight too.
+var resetRE = regexp.MustCompile(`\x1b\[0[0-9;]*m`)
+
+// highlightRow makes every cell of row bold, renewing the bold after each of
+// the resets in the cells so it covers them whole.
+func highlightRow(row table.Row) {
+	if color.NoColor {
+		return
+	}
+	bold := text.Bold.EscapeSeq()
+	for i, cell := range row {
+		s := fmt.Sprint(cell)
+		if s == "" {
+			continue
+		}
+		row[i] = bold + resetRE.ReplaceAllString(s, "${0}"+bold) + text.EscapeReset
+	}
+}
+
+// prettySignature marks a signed commit with a green check when its signature
+// verifies against the --keyring, or a yellow question mark when it doesn't
+// or there's no keyring to check it with, as with SSH signatures. Unsigned
+// commits get nothing.
+func prettySignature(commit *object.Commit, pa *Log) string {
+	if commit.PGPSignature == "" {
+		return ""
+	}
+	if pa.keyring != "" {
+		if _, err := commit.Verify(pa.keyring); err == nil {
+			return color.GreenString("✓")
+		}
+	}
+	return color.YellowString("?")
+}
+
+// appendDetailRow adds a row with text in the message column only.
+func appendDetailRow(text string, tw *table.Writer, pa *Log) {
+	(*tw).AppendRow(sparseRow("", text, pa))
+}
+
+// validColumns are the columns the log table can show, in their default
+// order. signature, committer-date, signoff and size are only shown by
+// default with the flags that ask for them.
+var validColumns = []string{"hash", "signature", "date", "committer-date", "author", "signoff", "diff", "size", "message"}
+
+// columnHeaders are the headers of the columns, for markdown.
+var columnHeaders = map[string]string{
+	"hash":           "Hash",
+	"signature":      "Signed",
+	"date":           "When",
+	"committer-date": "Committed",
+	"author":         "Author",
+	"signoff":        "Signed off",
+	"diff":           "Changes",
+	"size":           "Size",
+	"message":        "Message",
+}
+
+// resolveColumns returns the columns of the log table: those given with
+// --columns, or else the default ones plus those turned on by
+// --show-signature, --show-committer-date, --trailers and --sizes, less the
+// diff with --no-diff. Given columns must include those the other flags need,
+// rather than the flags being quietly ignored.
+func resolveColumns(a Options) ([]string, error) {
+	if a.Columns == "" {
+		return slices.DeleteFunc(slices.Clone(validColumns), func(column string) bool {
+			return (column == "signature" && !a.Signatures) || (column == "committer-date" && !a.CommitterDate) || (column == "signoff" && !a.Trailers) || (column == "size" && !a.Sizes) || (column == "diff" && a.NoDiff)
+		}), nil
+	}
+	var columns []string
+	for _, column := range strings.Split(a.Columns, ",") {
+		column = strings.TrimSpace(column)
+		if !slices.Contains(validColumns, column) {
+			return nil, fmt.Errorf("the provided column %s is invalid; must be one of %s", column, strings.Join(validColumns, ", "))
+		}
+		columns = append(columns, column)
+	}
+	// --name-stat, --group-by-day and --worktree put their rows under the
+	// message
+	for _, need := range []struct {
+		set          bool
+		flag, column string
+	}{
+		{a.Signatures, "--show-signature", "signature"},
+		{a.CommitterDate, "--show-committer-date", "committer-date"},
+		{a.Trailers, "--trailers", "signoff"},
+		{a.Sizes, "--sizes", "size"},
+		{a.NameStat, "--name-stat", "message"},
+		{a.GroupByDay, "--group-by-day", "message"},
+		{a.Worktree, "--worktree", "message"},
+	} {
+		if need.set && !slices.Contains(columns, need.column) {
+			return nil, fmt.Errorf("%s needs the %s column, which isn't among the columns chosen", need.flag, need.column)
+		}
+	}
+	return columns, nil
+}
+
+// headerRow names the columns shown by appendCommitRow.
+func headerRow(pa *Log) table.Row {
+	row := make(table.Row, 0, len(pa.columns))
+	for _, column := range pa.columns {
+		row = append(row, columnHeaders[column])
+	}
+	return row
+}
+
+// printLegend writes a key to the colors of the columns shown, drawn with the
+// same helpers as the table so it matches whatever --color and the git
+// config made of them.
+func printLegend(out io.Writer, pa *Log) {
+	var keys []string
+	for _, column := range pa.columns {
+		switch column {
+		case "hash":
+			keys = append(keys, color.YellowString("hash"))
+		case "signature":
+			keys = append(keys, color.GreenString("✓")+" verified signature", color.YellowString("?")+" unverified signature")
+		case "date":
+			keys = append(keys, color.GreenString("when authored"))
+		case "committer-date":
+			keys = append(keys, color.GreenString("when committed"))
+		case "signoff":
+			keys = append(keys, color.GreenString("DCO")+" signed off by the author", color.YellowString("DCO?")+" signed off by others only")
+		case "author":
+			if pa.colorAuthors {
+				keys = append(keys, "author (a color each)")
+			} else {
+				keys = append(keys, color.New(color.FgBlue).Add(color.Bold).Sprint("author"))
+			}
+		case "diff":
+			if pa.diffStyle == "bar" && !color.NoColor {
+				keys = append(keys, diffBar(diffStat{insertions: 1}, diffBarWidth)+" lines added", diffBar(diffStat{deletions: 1}, diffBarWidth)+" lines removed")
+			} else if pa.diffStyle == "net" {
+				keys = append(keys, netText(diffStat{insertions: 1})+" net lines added", netText(diffStat{deletions: 1})+" net lines removed")
+			} else {
+				keys = append(keys, formatDiffStat(diffStat{files: 1})+" files changed", formatDiffStat(diffStat{insertions: 1})+" lines added", formatDiffStat(diffStat{deletions: 1})+" lines removed", formatDiffStat(diffStat{binaries: 1})+" binary files")
+			}
+		case "size":
+			keys = append(keys, color.CyanString("bytes added"))
+		case "message":
+			refs := []plumbing.ReferenceName{plumbing.HEAD, plumbing.NewBranchReferenceName("branch"), plumbing.NewRemoteReferenceName("remote", "branch"), plumbing.NewTagReferenceName("tag")}
+			for _, ref := range refs {
+				keys = append(keys, refColor(ref).Sprintf("(%s)", ref.Short()))
+			}
+		}
+	}
+	fmt.Fprintf(out, "key: %s\n", strings.Join(keys, ", "))
+}
+
+// sparseRow is a row with only the diff and message columns filled in, in
+// the same layout as the rows of appendCommitRow.
+func sparseRow(diff string, message string, pa *Log) table.Row {
+	row := make(table.Row, 0, len(pa.columns))
+	for _, column := range pa.columns {
+		switch column {
+		case "diff":
+			row = append(row, diff)
+		case "message":
+			row = append(row, message)
+		default:
+			row = append(row, "")
+		}
+	}
+	return row
+}
+
+// prettyBase names the base commit by the refs pointing at it, preferring the
+// name it was given on the command line, and falls back to its short hash.
+func prettyBase(pa *Log, refHashToName map[string][]plumbing.ReferenceName) string {
+	if pa.baseCommit == nil {
+		return "none"
+	}
+	names := shortRefNames(refHashToName[pa.baseCommit.Hash.String()])
+	if len(names) == 0 {
+		return prettyHash(pa.baseCommit, pa)
+	}
+	if slices.Contains(names, pa.baseName) {
+		names = []string{pa.baseName}
+	}
+	formattedNames := make([]string, 0, len(names))
+	for _, name := range names {
+		formattedNames = append(formattedNames, color.RedString(name))
+	}
+	return fmt.Sprintf("%s (%s)", strings.Join(formattedNames, ", "), prettyHash(pa.baseCommit, pa))
+}
+
+// forgeCommitURL turns a GitHub or GitLab remote URL, in either its SSH or
+// HTTPS form, into the URL a commit's hash is appended to to view it on the
+// web. Remotes on other hosts give "".
+func forgeCommitURL(remoteURL string) string {
+	var host, repoPath string
+	if strings.Contains(remoteURL, "://") {
+		u, err := url.Parse(remoteURL)
+		if err != nil {
+			return ""
+		}
+		host, repoPath = u.Hostname(), u.Path
+	} else if userHost, p, ok := strings.Cut(remoteURL, ":"); ok {
+		// scp-like syntax, e.g. git@github.com:owner/repo.git
+		_, host, _ = strings.Cut(userHost, "@")
+		if host == "" {
+			host = userHost
+		}
+		repoPath = p
+	}
+	repoPath = strings.TrimSuffix(strings.Trim(repoPath, "/"), ".git")
+	if repoPath == "" {
+		return ""
+	}
+	switch host {
+	case "github.com":
+		return "https://github.com/" + repoPath + "/commit/"
+	case "gitlab.com":
+		return "https://gitlab.com/" + repoPath + "/-/commit/"
+	}
+	return ""
+}
+
+// shortHash abbreviates hash as --abbrev, --full-hash or --abbrev=auto asked.
+func shortHash(hash plumbing.Hash, pa *Log) string {
+	return hash.String()[:pa.abbrev]
+}
+
+func prettyHash(commit *object.Commit, pa *Log) string {
+	hash := color.YellowString(shortHash(commit.Hash, pa))
+	if pa.commitURL != "" {
+		hash = text.Hyperlink(pa.commitURL+commit.Hash.String(), hash)
+	}
+	return hash
+}
+
+func prettyRelativeTime(commit *object.Commit, pa *Log) string {
+	when := formatDate(commit.Author.When, pa)
+	if pa.authorTZ {
+		// When keeps the zone recorded in the commit, so this is the
+		// author's own wall clock rather than the viewer's
+		when = fmt.Sprintf("%s (%s)", when, commit.Author.When.Format("15:04 -0700"))
+	}
+	return color.GreenString(when)
+}
+
+// prettyCommitterTime is when the commit was made, which differs from when it
+// was authored once it has been rebased or cherry-picked.
+func prettyCommitterTime(commit *object.Commit, pa *Log) string {
+	return color.GreenString(formatDate(commit.Committer.When, pa))
+}
+
+// formatDate renders t in the --date format.
+func formatDate(t time.Time, pa *Log) string {
+	switch pa.date {
+	case "relative":
+		return gotime.TimeAgo(t)
+	case "relative-human":
+		return humanRelativeTime(t, time.Now(), pa.dateLabels)
+	case "iso":
+		return t.Format("2006-01-02 15:04:05 -0700")
+	case "short":
+		return t.Format("2006-01-02")
+	}
+	return gotime.Format(t, pa.date)
+}
+
+// authorColors are the colors authors are told apart by.
+var authorColors = []color.Attribute{color.FgBlue, color.FgMagenta, color.FgCyan, color.FgGreen, color.FgYellow, color.FgHiBlue, color.FgHiMagenta, color.FgHiCyan}
+
+// prettyAuthor shows the commit's author in pa.authorFormat, colored by their
+// email so each author keeps the same color whichever way they're shown, or
+// all in blue without --color-authors. With --trailers or --full, the number
+// of co-authors follows.
+func prettyAuthor(commit *object.Commit, pa *Log) string {
+	var author string
+	switch pa.authorFormat {
+	case "email":
+		author = commit.Author.Email
+	case "initials":
+		author = initials(commit.Author.Name)
+	default:
+		author = commit.Author.Name
+	}
+	c := color.New(color.FgBlue)
+	if pa.colorAuthors {
+		h := fnv.New32a()
+		h.Write([]byte(strings.ToLower(commit.Author.Email)))
+		c = color.New(authorColors[h.Sum32()%uint32(len(authorColors))])
+	}
+	author = c.Add(color.Bold).Sprint(author)
+	if pa.trailers {
+		// credit pairs and mobs, who git only knows of by their trailers
+		if n := len(trailerValues(commit.Message, "Co-authored-by")); n > 0 {
+			author += color.New(color.Faint).Sprintf(" (+%d)", n)
+		}
+	}
+	return author
+}
+
+// trailerRE matches a "Key: value" trailer line.
+var trailerRE = regexp.MustCompile(`^([A-Za-z0-9-]+):\s*(.*\S)\s*$`)
+
+// trailerValues returns the values of the trailers called key, ignoring
+// case, in the last paragraph of a commit message, which is where git
+// interpret-trailers puts them. A paragraph that isn't all trailers has none.
+func trailerValues(message, key string) []string {
+	paragraphs := strings.Split(strings.TrimSpace(strings.ReplaceAll(message, "\r\n", "\n")), "\n\n")
+	if len(paragraphs) < 2 {
+		// a subject alone has no trailers
+		return nil
+	}
+	var values []string
+	for _, line := range strings.Split(paragraphs[len(paragraphs)-1], "\n") {
+		m := trailerRE.FindStringSubmatch(line)
+		if m == nil {
+			return nil
+		}
+		if strings.EqualFold(m[1], key) {
+			values = append(values, m[2])
+		}
+	}
+	return values
+}
+
+// prettySignoff marks a commit with Signed-off-by trailers, as the Developer
+// Certificate of Origin asks: in green when the author signed off, or yellow
+// when only others did.
+func prettySignoff(commit *object.Commit) string {
+	signoffs := trailerValues(commit.Message, "Signed-off-by")
+	if len(signoffs) == 0 {
+		return ""
+	}
+	email := "<" + strings.ToLower(commit.Author.Email) + ">"
+	if slices.ContainsFunc(signoffs, func(signoff string) bool {
+		return strings.HasSuffix(strings.ToLower(signoff), email)
+	}) {
+		return color.GreenString("DCO")
+	}
+	return color.YellowString("DCO?")
+}
+
+// initials abbreviates a name to the first letter of each of its words, e.g.
+// "Jane Doe" to "JD".
+func initials(name string) string {
+	var b strings.Builder
+	for _, word := range strings.Fields(name) {
+		r, _ := utf8.DecodeRuneInString(word)
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// prettySubject returns the first line of the commit message.
+func prettySubject(commit *object.Commit) string {
+	return strings.TrimSpace(strings.SplitN(commit.Message, "\n", 2)[0])
+}
+
+func prettyMessage(commit *object.Commit, refHashToName map[string][]plumbing.ReferenceName, pa *Log) string {
+	messageLines := strings.SplitN(commit.Message, "\n", 2)
+	message := strings.TrimSpace(messageLines[0])
+	var badge string
+	if pa.conventional {
+		badge, message = conventionalBadge(message)
+	}
+	if pa.full {
+		// set the subject apart from the body beneath it
+		message = color.New(color.Bold).Sprint(message)
+	}
+	if badge != "" {
+		message = badge + " " + message
+	}
+	if refNames, ok := refHashToName[commit.Hash.String()]; ok {
+		formattedRefNames := make([]string, 0, len(refNames))
+		for _, rn := range refNames {
+			formattedRefNames = append(formattedRefNames, refColor(rn).Sprintf("(%s)", rn.Short()))
+		}
+		message = fmt.Sprintf("%s %s", strings.Join(formattedRefNames, ""), message)
+	}
+	if pa.full && len(messageLines) > 1 {
+		if body := strings.TrimSpace(messageLines[1]); body != "" {
+			// wrapped line by line so the paragraphs and trailers keep
+			// their breaks
+			lines := strings.Split(body, "\n")
+			for i, line := range lines {
+				lines[i] = text.WrapSoft(strings.TrimRight(line, " \t"), fullMessageWidth)
+			}
+			message += "\n" + strings.Join(lines, "\n")
+		}
+	}
+	return message
+}
+
+// fullMessageWidth is the width the bodies of --full messages are wrapped
+// to, wide enough for bodies already wrapped at git's customary 72 columns.
+const fullMessageWidth = 80
+
+// conventionalRE matches a Conventional Commits subject, capturing the
+// type(scope)! prefix, the type alone and the description.
+var conventionalRE = regexp.MustCompile(`^((\w+)(?:\([^)]*\))?!?): (.*)$`)
+
+// conventionalColors are the badge colors of the common commit types. Other
+// types get a neutral badge.
+var conventionalColors = map[string]color.Attribute{
+	"feat":     color.BgGreen,
+	"fix":      color.BgRed,
+	"perf":     color.BgMagenta,
+	"refactor": color.BgMagenta,
+	"docs":     color.BgBlue,
+	"test":     color.BgCyan,
+	"build":    color.BgYellow,
+	"ci":       color.BgYellow,
+	"chore":    color.BgYellow,
+	"revert":   color.BgRed,
+}
+
+// conventionalBadge splits the type(scope): prefix off a Conventional Commits
+// subject, returning it as a badge colored by type along with the rest of the
+// subject. Badges only read in color, so without it, or when the subject
+// doesn't follow the convention, the badge is empty and the subject is left
+// alone.
+func conventionalBadge(subject string) (string, string) {
+	m := conventionalRE.FindStringSubmatch(subject)
+	if m == nil || color.NoColor {
+		return "", subject
+	}
+	bg, ok := conventionalColors[strings.ToLower(m[2])]
+	if !ok {
+		bg = color.BgHiBlack
+	}
+	return color.New(bg, color.FgHiWhite).Sprintf(" %s ", m[1]), m[3]
+}
+
+// authorMatches reports whether the commit's author name or email contains
+// any of the given lowercased substrings.
+func authorMatches(commit *object.Commit, authors []string) bool {
+	name := strings.ToLower(commit.Author.Name)
+	email := strings.ToLower(commit.Author.Email)
+	for _, author := range authors {
+		if strings.Contains(name, author) || strings.Contains(email, author) {
+			return true
+		}
+	}
+	return false
+}
+
+// grepBody reports whether the commit message matches re and, if so, returns
+// the first matching body line with a line of context on either side and the
+// matched spans highlighted. A match only in the subject yields no lines.
+func grepBody(commit *object.Commit, re *regexp.Regexp) ([]string, bool) {
+	if !re.MatchString(commit.Message) {
+		return nil, false
+	}
+	messageLines := strings.Split(strings.TrimSpace(commit.Message), "\n")
+	body := messageLines[1:]
+	for i, line := range body {
+		if !re.MatchString(line) {
+			continue
+		}
+		context := make([]string, 0, 3)
+		for j := max(0, i-1); j < min(len(body), i+2); j++ {
+			l := strings.TrimSpace(body[j])
+			if l == "" {
+				continue
+			}
+			if j == i {
+				l = re.ReplaceAllStringFunc(l, func(m string) string {
+					return color.New(color.FgMagenta).Add(color.Bold).Sprint(m)
+				})
+			} else {
+				l = color.New(color.Faint).Sprint(l)
+			}
+			context = append(context, l)
+		}
+		return context, true
+	}
+	return nil, true
+}
+
+var validDateModes = []string{"relative", "relative-human", "iso", "short"}
+
+// dateLayoutTokens are the fields gotime understands in a layout, keyed by
+// their first letter and longest first, the order gotime matches them in.
+var dateLayoutTokens = map[byte][]string{
+	'y': {"yyyy", "yy"},
+	'm': {"mmmm", "mmm", "mm", "mt", "m"},
+	'd': {"ddd", "dd", "db", "dt", "d"},
+	'w': {"wwww", "www"},
+	'h': {"hhh", "hh", "h"},
+	'a': {"aa", "a"},
+	'i': {"ii", "i"},
+	's': {"ss", "s"},
+	'z': {"zz", "z"},
+	'o': {"ooo", "oo", "o"},
+}
+
+// validateDateLayout checks a custom --date layout. gotime formats any string
+// without complaint, so stray letters would otherwise come out as garbage on
+// every row. Literal letters have to be escaped with a backslash.
+func validateDateLayout(layout string) error {
+	fields := 0
+	for i := 0; i < len(layout); {
+		c := layout[i]
+		if c == '\\' {
+			i += 2
+			continue
+		}
+		lower := c | 0x20
+		if lower < 'a' || lower > 'z' {
+			i++
+			continue
+		}
+		var field string
+		for _, t := range dateLayoutTokens[lower] {
+			if strings.HasPrefix(strings.ToLower(layout[i:]), t) {
+				field = t
+				break
+			}
+		}
+		switch field {
+		case "":
+			return fmt.Errorf("unknown field %q at position %d (escape literal letters with \\)", c, i+1)
+		case "mt", "dt":
+			// gotime writes debug output to stdout for ordinals
+			return fmt.Errorf("ordinal field %s isn't supported", field)
+		}
+		fields++
+		i += len(field)
+	}
+	if fields == 0 {
+		return errors.New("no date or time fields")
+	}
+	return nil
+}
+
+// humanDateLabels controls the phrasing of relative-human dates. thisWeek and
+// older are layouts like those of --date, such as yyyy-mm-dd, applied to
+// commits from the last week and beyond.
+type humanDateLabels struct {
+	today     string
+	yesterday string
+	thisWeek  string
+	older     string
+}
+
+var defaultHumanDateLabels = humanDateLabels{
+	today:     "today",
+	yesterday: "yesterday",
+	thisWeek:  "wwww",
+	older:     "yyyy-mm-dd",
+}
+
+// parseHumanDateLabels applies key=value overrides such as
+// "today=Today,this-week=Mon" on top of the default labels.
+func parseHumanDateLabels(s string) (humanDateLabels, error) {
+	labels := defaultHumanDateLabels
+	if s == "" {
+		return labels, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return labels, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		switch strings.TrimSpace(key) {
+		case "today":
+			labels.today = value
+		case "yesterday":
+			labels.yesterday = value
+		case "this-week":
+			labels.thisWeek = value
+		case "older":
+			labels.older = value
+		default:
+			return labels, fmt.Errorf("unknown label %q; must be one of today, yesterday, this-week, older", key)
+		}
+	}
+	if err := validateDateLayout(labels.thisWeek); err != nil {
+		return labels, fmt.Errorf("this-week layout %q: %w", labels.thisWeek, err)
+	}
+	if err := validateDateLayout(labels.older); err != nil {
+		return labels, fmt.Errorf("older layout %q: %w", labels.older, err)
+	}
+	return labels, nil
+}
+
+// humanRelativeTime phrases t relative to now by calendar day rather than
+// elapsed duration, so a commit from late last night reads as "yesterday".
+func humanRelativeTime(t, now time.Time, labels humanDateLabels) string {
+	t = t.In(now.Location())
+	year, month, day := now.Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	switch {
+	case !t.Before(today):
+		return labels.today
+	case !t.Before(today.AddDate(0, 0, -1)):
+		return labels.yesterday
+	case !t.Before(today.AddDate(0, 0, -6)):
+		return gotime.Format(t, labels.thisWeek)
+	default:
+		return gotime.Format(t, labels.older)
+	}
+}
+
+// dateFilterLayouts are the absolute forms accepted by --since and --until,
+// read in the local timezone unless they carry their own.
+var dateFilterLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+}
+
+var relativeDateRE = regexp.MustCompile(`^(\d+)\s*(second|minute|hour|day|week|month|year)s?\s+ago$`)
+
+// parseDateFilter parses a --since or --until value: an absolute date such as
+// 2024-01-02 or 2024-01-02 15:04, or a phrase relative to now such as
+// "7 days ago", "2.weeks.ago", "yesterday" or "today". Values naming a whole
+// day mean its start, or its end when endOfDay is set, so that an --until
+// date includes the day itself.
+func parseDateFilter(value string, now time.Time, endOfDay bool) (time.Time, error) {
+	day := func(t time.Time) time.Time {
+		if endOfDay {
+			return gotime.EoD(t)
+		}
+		return gotime.SoD(t)
+	}
+	for _, layout := range dateFilterLayouts {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			if layout == "2006-01-02" {
+				return day(t), nil
+			}
+			return t, nil
+		}
+	}
+
+	// git also accepts dots between the words, e.g. 2.weeks.ago
+	phrase := strings.Join(strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return r == ' ' || r == '.'
+	}), " ")
+	switch phrase {
+	case "now":
+		return now, nil
+	case "today":
+		return day(now), nil
+	case "yesterday":
+		return day(gotime.Days(-1, now)), nil
+	}
+	m := relativeDateRE.FindStringSubmatch(phrase)
+	if m == nil {
+		return time.Time{}, errors.New(`must be a date such as 2024-01-02 or a phrase such as "7 days ago"`)
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch m[2] {
+	case "second":
+		return now.Add(-time.Duration(n) * time.Second), nil
+	case "minute":
+		return now.Add(-time.Duration(n) * time.Minute), nil
+	case "hour":
+		return now.Add(-time.Duration(n) * time.Hour), nil
+	case "day":
+		return gotime.Days(-n, now), nil
+	case "week":
+		return gotime.Weeks(-n, now), nil
+	case "month":
+		return gotime.Months(-n, now), nil
+	}
+	return gotime.Years(-n, now), nil
+}
+
+// firstParentChanges returns the tree changes a commit made relative to its
+// first parent, or to the empty tree for a root commit.
+func firstParentChanges(commit *object.Commit) (object.Changes, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var parentTree *object.Tree
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+		parentTree, err = parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return object.DiffTree(parentTree, tree)
+}
+
+// commitBytesAdded sums the sizes of the blobs a commit added or modified
+// relative to its first parent, i.e. how much new content it introduced.
+func commitBytesAdded(repo *git.Repository, commit *object.Commit) (int64, error) {
+	changes, err := firstParentChanges(commit)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, change := range changes {
+		// deletions have no destination blob
+		if change.To.Name == "" || !change.To.TreeEntry.Mode.IsFile() {
+			continue
+		}
+		blob, err := repo.BlobObject(change.To.TreeEntry.Hash)
+		if err != nil {
+			return 0, err
+		}
+		total += blob.Size
+	}
+	return total, nil
+}
+
+// followedChange returns the change the commit made, relative to its first
+// parent, to the file called name, or nil if it didn't touch it. A rename is
+// detected even when --find-renames is off, as following the file is the
+// point, so the change's From names the file as it was before the commit.
+func followedChange(commit *object.Commit, name string, pa *Log) (*object.Change, error) {
+	changes, err := firstParentChanges(commit)
+	if err != nil {
+		return nil, err
+	}
+	isFollowed := func(change *object.Change) bool {
+		return change.To.Name == name
+	}
+	if !slices.ContainsFunc(changes, isFollowed) {
+		return nil, nil
+	}
+	// a rename can only be from a file the commit deleted
+	changes = slices.DeleteFunc(changes, func(change *object.Change) bool {
+		return !isFollowed(change) && change.To.Name != ""
+	})
+	score := pa.renameScore
+	if score == 0 {
+		score = defaultRenameScore
+	}
+	changes, err = object.DetectRenames(changes, &object.DiffTreeOptions{
+		DetectRenames: true,
+		RenameScore:   uint(score),
+		RenameLimit:   1000,
+	})
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(changes, isFollowed)
+	if i < 0 {
+		return nil, nil
+	}
+	return changes[i], nil
+}
+
+// touchesPaths reports whether the commit changed a path matching any of
+// paths relative to each of its parents. Like `git log -- <path>`, a merge
+// that took those paths unchanged from one of its parents doesn't count.
+func touchesPaths(commit *object.Commit, paths []*regexp.Regexp) (bool, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return false, err
+	}
+	parentTrees := []*object.Tree{nil}
+	if commit.NumParents() > 0 {
+		parentTrees = parentTrees[:0]
+		err := commit.Parents().ForEach(func(parent *object.Commit) error {
+			parentTree, err := parent.Tree()
+			parentTrees = append(parentTrees, parentTree)
+			return err
+		})
+		if err != nil {
+			return false, err
+		}
+	}
+	for _, parentTree := range parentTrees {
+		changes, err := object.DiffTree(parentTree, tree)
+		if err != nil {
+			return false, err
+		}
+		touched := slices.ContainsFunc(changes, func(change *object.Change) bool {
+			return slices.ContainsFunc(paths, func(re *regexp.Regexp) bool {
+				return re.MatchString(change.From.Name) || re.MatchString(change.To.Name)
+			})
+		})
+		if !touched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// pickaxeMatches reports whether the commit changed the number of
+// occurrences of pa.pickaxe in any file, like `git log -S`, or added or
+// removed a line matching pa.pickaxeRegex, like `git log -G`.
+func pickaxeMatches(commit *object.Commit, pa *Log) (bool, error) {
+	changes, err := firstParentChanges(commit)
+	if err != nil {
+		return false, err
+	}
+	for _, change := range changes {
+		if pa.pickaxe != "" {
+			from, to, err := change.Files()
+			if err != nil {
+				return false, err
+			}
+			before, err := fileContents(from)
+			if err != nil {
+				return false, err
+			}
+			after, err := fileContents(to)
+			if err != nil {
+				return false, err
+			}
+			if strings.Count(before, pa.pickaxe) != strings.Count(after, pa.pickaxe) {
+				return true, nil
+			}
+		}
+		if pa.pickaxeRegex != nil {
+			patch, err := change.Patch()
+			if err != nil {
+				return false, err
+			}
+			for _, fp := range patch.FilePatches() {
+				for _, chunk := range fp.Chunks() {
+					if chunk.Type() == diff.Equal {
+						continue
+					}
+					for _, line := range strings.Split(chunk.Content(), "\n") {
+						if pa.pickaxeRegex.MatchString(line) {
+							return true, nil
+						}
+					}
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// fileContents returns the contents of f, or "" when the file doesn't exist
+// on that side of a change.
+func fileContents(f *object.File) (string, error) {
+	if f == nil {
+		return "", nil
+	}
+	return f.Contents()
+}
+
+// humanBytes formats n using binary units, e.g. 1536 -> "1.5 KiB".
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// diffStat is the summary of a diff, summed from its fileStats: the files
+// changed, the lines inserted and deleted, and how many of the files are
+// binary and so have no lines counted.
+type diffStat struct {
+	files      int
+	insertions int
+	deletions  int
+	binaries   int
+}
+
+func (d diffStat) Add(other diffStat) diffStat {
+	return diffStat{
+		files:      d.files + other.files,
+		insertions: d.insertions + other.insertions,
+		deletions:  d.deletions + other.deletions,
+		binaries:   d.binaries + other.binaries,
+	}
+}
+
+// defaultRenameScore is how similar, in percent, a deleted and an added file
+// must be to count as a rename by default, as in git diff.
+const defaultRenameScore = 50
+
+// renameScore is the value of --find-renames, the similarity threshold for
+// renames in percent, or 0 when renames aren't looked for. Like git's
+// --find-renames[=<n>] the threshold is optional.
+type RenameScore int
+
+func (r *RenameScore) String() string {
+	return strconv.Itoa(int(*r))
+}
+
+func (r *RenameScore) Set(value string) error {
+	if on, err := strconv.ParseBool(value); err == nil {
+		*r = 0
+		if on {
+			*r = defaultRenameScore
+		}
+		return nil
+	}
+	score, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+	if err != nil || score < 1 || score > 100 {
+		return fmt.Errorf("%s is not a percentage from 1 to 100", value)
+	}
+	*r = RenameScore(score)
+	return nil
+}
+
+func (r *RenameScore) IsBoolFlag() bool {
+	return true
+}
+
+// fileStat is what one file of a diff computed by getFileStats changed. name
+// is "old -> new" for renamed files. Binary files have no lines counted.
+type fileStat struct {
+	name       string
+	renamed    bool
+	binary     bool
+	insertions int
+	deletions  int
+}
+
+// getDiffStat computes the equivalent of `git diff --shortstat` of commit
+// against ancestor, honoring the excluded pathspecs. A nil ancestor diffs
+// against the empty tree.
+func getDiffStat(commit, ancestor *object.Commit, pa *Log) (diffStat, error) {
+	files, err := getFileStats(commit, ancestor, pa)
+	if err != nil {
+		return diffStat{}, err
+	}
+	return sumFileStats(files), nil
+}
+
+// sumFileStats adds up per-file stats. Every file counts as changed, even
+// binary files and pure renames with no lines to show.
+func sumFileStats(files []fileStat) diffStat {
+	stat := diffStat{files: len(files)}
+	for _, f := range files {
+		stat.insertions += f.insertions
+		stat.deletions += f.deletions
+		if f.binary {
+			stat.binaries++
+		}
+	}
+	return stat
+}
+
+// getFileStats computes the equivalent of `git diff --numstat` of commit
+// against ancestor, in the same way as getDiffStat.
+func getFileStats(commit, ancestor *object.Commit, pa *Log) ([]fileStat, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var ancestorTree *object.Tree
+	if ancestor != nil {
+		ancestorTree, err = ancestor.Tree()
+		if err != nil {
+			return nil, err
+		}
+	}
+	changes, err := object.DiffTree(ancestorTree, tree)
+	if err != nil {
+		return nil, err
+	}
+	// like git, limit the paths before looking for renames, so a file moved
+	// out of an excluded directory counts as added rather than renamed
+	changes = slices.DeleteFunc(changes, func(change *object.Change) bool {
+		return !pathInScope(changePath(change), pa)
+	})
+	if pa.renameScore > 0 {
+		changes, err = object.DetectRenames(changes, &object.DiffTreeOptions{
+			DetectRenames: true,
+			RenameScore:   uint(pa.renameScore),
+			RenameLimit:   1000,
+		})
+		if err != nil {
+			return nil, err
+		}
+		changes = pairModeChangeRenames(changes)
+	}
+	patch, err := changes.Patch()
+	if err != nil {
+		return nil, err
+	}
+	// the file patches are walked directly rather than through patch.Stats(),
+	// which leaves out binary files and pure renames that git still lists
+	var files []fileStat
+	for _, fp := range patch.FilePatches() {
+		from, to := fp.Files()
+		var f fileStat
+		switch {
+		case from == nil:
+			f.name = to.Path()
+		case to == nil:
+			f.name = from.Path()
+		case from.Path() != to.Path():
+			f.name = from.Path() + " -> " + to.Path()
+			f.renamed = true
+		default:
+			f.name = to.Path()
+		}
+		// go-git gives binary files no chunks, so they'd otherwise look
+		// like pure renames or mode changes
+		f.binary = fp.IsBinary()
+		for _, chunk := range fp.Chunks() {
+			switch chunk.Type() {
+			case diff.Add:
+				f.insertions += countLines(chunk.Content())
+			case diff.Delete:
+				f.deletions += countLines(chunk.Content())
+			}
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// countLines counts the lines of a patch chunk, the last of which may lack
+// a newline.
+func countLines(s string) int {
+	n := strings.Count(s, "\n")
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		n++
+	}
+	return n
+}
+
+// pairModeChangeRenames pairs up deleted and added files with identical
+// contents but different modes, e.g. a file made executable as it was moved.
+// git counts these as renames, but go-git only finds renames that keep the
+// mode.
+func pairModeChangeRenames(changes object.Changes) object.Changes {
+	deleted := make(map[plumbing.Hash]int)
+	for i, change := range changes {
+		if change.To.Name == "" {
+			deleted[change.From.TreeEntry.Hash] = i
+		}
+	}
+	paired := make(map[int]bool)
+	for _, change := range changes {
+		if change.From.Name != "" {
+			continue
+		}
+		i, ok := deleted[change.To.TreeEntry.Hash]
+		if !ok || paired[i] {
+			continue
+		}
+		change.From = changes[i].From
+		paired[i] = true
+	}
+	kept := make(object.Changes, 0, len(changes)-len(paired))
+	for i, change := range changes {
+		if !paired[i] {
+			kept = append(kept, change)
+		}
+	}
+	return kept
+}
+
+// changePath is the path a tree change applies to, which before rename
+// detection is the same on both sides unless it's an addition.
+func changePath(change *object.Change) string {
+	if change.To.Name != "" {
+		return change.To.Name
+	}
+	return change.From.Name
+}
+
+// pathInScope reports whether a path counts towards diff stats: it must be
+// under the --cwd-only directory, if any, match an include, if any, and not
+// be excluded.
+func pathInScope(name string, pa *Log) bool {
+	if pa.scope != "" && name != pa.scope && !strings.HasPrefix(name, pa.scope+"/") {
+		return false
+	}
+	if len(pa.include) > 0 && !slices.ContainsFunc(pa.include, func(re *regexp.Regexp) bool { return re.MatchString(name) }) {
+		return false
+	}
+	for _, re := range pa.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// OpenRepository opens the repository containing path, like git looking
+// upwards from a subdirectory or a linked worktree. As with git, GIT_DIR names
+// the repository instead, with GIT_WORK_TREE as its worktree or else the
+// current directory.
+func OpenRepository(path string) (*git.Repository, error) {
+	gitDir := os.Getenv("GIT_DIR")
+	if gitDir == "" {
+		repo, err := git.PlainOpenWithOptions(path, &git.PlainOpenOptions{DetectDotGit: true, EnableDotGitCommonDir: true})
+		if errors.Is(err, git.ErrRepositoryNotExists) {
+			// detection only looks for a .git directory, which a bare
+			// repository like a git clone --bare is without
+			return git.PlainOpenWithOptions(path, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+		}
+		return repo, err
+	}
+	var dotGit billy.Filesystem = osfs.New(gitDir)
+	// the git dir of a linked worktree shares the objects and refs of the
+	// main one, which it names in commondir
+	if common, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir := strings.TrimSpace(string(common))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+		dotGit = dotgit.NewRepositoryFilesystem(dotGit, osfs.New(commonDir))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	workTree := os.Getenv("GIT_WORK_TREE")
+	if workTree == "" {
+		workTree = "."
+	}
+	return git.Open(filesystem.NewStorage(dotGit, cache.NewObjectLRUDefault()), osfs.New(workTree))
+}
+
+// repoSubdir returns dir relative to the top of the repository's worktree,
+// or "" when it is the top or outside it. Bare repositories have no
+// subdirectories.
+func repoSubdir(repo *git.Repository, dir string) (string, error) {
+	wt, err := repo.Worktree()
+	if errors.Is(err, git.ErrIsBareRepository) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(wt.Filesystem.Root(), abs)
+	if err != nil {
+		return "", err
+	}
+	// a directory outside the worktree, as GIT_WORK_TREE allows, has no
+	// place in it
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// pathspecPath is the path a pathspec names, relative to the top of the
+// repository, with its magic and any trailing slash taken off. Pathspecs
+// without :/ or :(top) are relative to prefix, the directory run from.
+func pathspecPath(spec, prefix string) string {
+	if top := strings.TrimPrefix(strings.TrimPrefix(spec, ":(top)"), ":/"); top != spec {
+		spec, prefix = top, ""
+	}
+	return strings.TrimPrefix(path.Clean("/"+prefix+"/"+spec), "/")
+}
+
+// compilePathspec turns a git pathspec into a regular expression matching
+// the paths it covers. A pathspec covers the path it names and everything
+// beneath it, and like git's default wildcard matching * and ? also match
+// across directories. As in git, pathspecs are relative to prefix, the
+// directory run from, unless they start with :/ or :(top), which is the only
+// pathspec magic understood. prefix itself is matched literally.
+func compilePathspec(spec, prefix string) (*regexp.Regexp, error) {
+	top := strings.HasPrefix(spec, ":/") || strings.HasPrefix(spec, ":(top)")
+	if strings.HasPrefix(spec, ":") && !top {
+		return nil, errors.New("pathspec magic isn't supported")
+	}
+	spec = pathspecPath(spec, prefix)
+	var b strings.Builder
+	b.WriteString("^")
+	if !top && prefix != "" {
+		// unless .. took the pathspec out of the directory
+		switch {
+		case spec == prefix:
+			b.WriteString(regexp.QuoteMeta(prefix))
+			spec = ""
+		case strings.HasPrefix(spec, prefix+"/"):
+			b.WriteString(regexp.QuoteMeta(prefix + "/"))
+			spec = strings.TrimPrefix(spec, prefix+"/")
+		}
+	}
+	for i := 0; i < len(spec); i++ {
+		switch c := spec[i]; c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(spec[i+1:], ']')
+			class := ""
+			if end >= 0 {
+				class = spec[i+1 : i+1+end]
+			}
+			negated := strings.HasPrefix(class, "!")
+			class = strings.TrimPrefix(class, "!")
+			if class == "" {
+				// not a class, so the bracket is just a bracket
+				b.WriteString(`\[`)
+				continue
+			}
+			b.WriteString("[")
+			if negated {
+				b.WriteString("^")
+			}
+			// only ranges keep their meaning inside a class
+			for _, r := range class {
+				if r == '-' {
+					b.WriteRune(r)
+				} else {
+					b.WriteString(regexp.QuoteMeta(string(r)))
+				}
+			}
+			b.WriteString("]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if b.Len() > 1 {
+		b.WriteString("(/|$)")
+	}
+	return regexp.Compile(b.String())
+}
+
+// formatDiffStat shows stat as counts of files, lines added and removed, and
+// binary files, leaving out those that are 0. A diff with none of them, e.g.
+// of an empty commit, is shown as 0 so it doesn't look like a missing diff.
+func formatDiffStat(stat diffStat) string {
+	parts := make([]string, 0, 4)
+	if stat.files > 0 {
+		parts = append(parts, color.YellowString("%d(~)", stat.files))
+	}
+	if stat.insertions > 0 {
+		parts = append(parts, color.GreenString("%d(+)", stat.insertions))
+	}
+	if stat.deletions > 0 {
+		parts = append(parts, color.RedString("%d(-)", stat.deletions))
+	}
+	if stat.binaries > 0 {
+		parts = append(parts, color.MagentaString("%d bin", stat.binaries))
+	}
+	if len(parts) == 0 {
+		return "0"
+	}
+	return strings.Join(parts, ",")
+}
+
+// diffBarWidth is the width in characters of the bar drawn for the largest
+// change in view.
+const diffBarWidth = 20
+
+// diffText is stat as shown in tables: as numbers colored by kind, or in
+// words for markdown, or with --diff=net as a single net change.
+func diffText(stat diffStat, pa *Log) string {
+	if pa.diffStyle == "net" {
+		return netText(stat)
+	}
+	if pa.format != "markdown" {
+		return formatDiffStat(stat)
+	}
+	lines := fmt.Sprintf("+%d -%d", stat.insertions, stat.deletions)
+	if stat.binaries > 0 {
+		lines += fmt.Sprintf(", %d bin", stat.binaries)
+	}
+	switch stat.files {
+	case 0:
+		// per-file rows have no file count
+		if stat.insertions == 0 && stat.deletions == 0 && stat.binaries == 0 {
+			return "0"
+		}
+		return lines
+	case 1:
+		return "1 file, " + lines
+	}
+	return fmt.Sprintf("%d files, %s", stat.files, lines)
+}
+
+// netText is the lines stat adds less those it removes, green when the net is
+// an addition and red when it's a removal, so it reads at a glance whether a
+// change grows or shrinks the code. Binary files, having no lines, are
+// marked when they are all that changed.
+func netText(stat diffStat) string {
+	net := stat.insertions - stat.deletions
+	switch {
+	case stat.insertions == 0 && stat.deletions == 0 && stat.binaries > 0:
+		return color.MagentaString("%d bin", stat.binaries)
+	case net > 0:
+		return color.GreenString("%+d", net)
+	case net < 0:
+		return color.RedString("%+d", net)
+	}
+	return "0"
+}
+
+func prettyDiff(stat diffStat, maxChanges int, pa *Log) string {
+	// bars only read well in color, so fall back to the numbers without it,
+	// or when there are no lines to draw, as for mode changes, binary files
+	// or empty commits
+	if pa.diffStyle != "bar" || color.NoColor || stat.insertions+stat.deletions == 0 {
+		return diffText(stat, pa)
+	}
+	return diffBar(stat, maxChanges)
+}
+
+// diffBar draws stat as a bar of insertions (green) and deletions (red) whose
+// length is proportional to maxChanges. Any change gets at least one block.
+func diffBar(stat diffStat, maxChanges int) string {
+	total := stat.insertions + stat.deletions
+	if total == 0 || maxChanges == 0 {
+		return ""
+	}
+	width := max(1, int(math.Round(float64(total)/float64(maxChanges)*diffBarWidth)))
+	added := int(math.Round(float64(width) * float64(stat.insertions) / float64(total)))
+	var bar strings.Builder
+	if added > 0 {
+		bar.WriteString(color.GreenString(strings.Repeat("█", added)))
+	}
+	if width-added > 0 {
+		bar.WriteString(color.RedString(strings.Repeat("█", width-added)))
+	}
+	return bar.String()
+}
@@ -0,0 +1,968 @@
+package prettylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// testRepo is a repository in a temporary directory that tests add commits
+// to, each a minute after the last so the log order is fixed.
+type testRepo struct {
+	t    *testing.T
+	dir  string
+	repo *git.Repository
+	when time.Time
+}
+
+// newTestRepo creates an empty repository on master. HOME is pointed at an
+// empty directory so neither the user's git config nor their rc file can
+// change what the tests see.
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("GIT_PAGER", "")
+	t.Setenv("NO_COLOR", "1")
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testRepo{t: t, dir: dir, repo: repo, when: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+// commit writes files, relative to the top of the worktree, and commits them
+// along with any other changes to tracked files.
+func (r *testRepo) commit(message string, files map[string]string) plumbing.Hash {
+	r.t.Helper()
+	return r.commitMerge(message, nil, files)
+}
+
+// commitMerge is commit with the given parents, or HEAD when there are none.
+func (r *testRepo) commitMerge(message string, parents []plumbing.Hash, files map[string]string) plumbing.Hash {
+	r.t.Helper()
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	for name, contents := range files {
+		path := filepath.Join(r.dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			r.t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			r.t.Fatal(err)
+		}
+		if _, err := wt.Add(name); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	r.when = r.when.Add(time.Minute)
+	sig := &object.Signature{Name: "Alice Smith", Email: "alice@example.com", When: r.when}
+	hash, err := wt.Commit(message, &git.CommitOptions{Author: sig, Committer: sig, Parents: parents, AllowEmptyCommits: true})
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	return hash
+}
+
+// branch creates a branch at HEAD and checks it out.
+func (r *testRepo) branch(name string) {
+	r.t.Helper()
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(name), Create: true}); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// setRef writes ref to the repository.
+func (r *testRepo) setRef(ref *plumbing.Reference) {
+	r.t.Helper()
+	if err := r.repo.Storer.SetReference(ref); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+// newFeatureRepo has two commits on master and two more on feature, which is
+// checked out.
+func newFeatureRepo(t *testing.T) *testRepo {
+	r := newTestRepo(t)
+	r.commit("initial commit", map[string]string{"a.txt": "a\n"})
+	r.commit("second commit", map[string]string{"a.txt": "a\nb\n"})
+	r.branch("feature")
+	r.commit("feat: add b", map[string]string{"b.txt": "one\ntwo\n"})
+	r.commit("feat: grow b", map[string]string{"b.txt": "one\ntwo\nthree\n"})
+	return r
+}
+
+// parseIn parses argv as git-pretty-log would for the repository at dir.
+func parseIn(dir string, argv ...string) (*Log, error) {
+	fs := flag.NewFlagSet("git-pretty-log", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var opts Options
+	opts.DefineFlags(fs)
+	if err := fs.Parse(argv); err != nil {
+		return nil, err
+	}
+	opts.RepoPath = dir
+	return opts.Parse(io.Discard)
+}
+
+// runIn parses argv for the repository at dir and runs the log, returning
+// what it printed and the exit code.
+func runIn(t *testing.T, dir string, argv ...string) (string, int) {
+	t.Helper()
+	pa, err := parseIn(dir, argv...)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", argv, err)
+	}
+	var buf bytes.Buffer
+	code, err := pa.Run(&buf)
+	if err != nil {
+		t.Fatalf("running %q: %v", argv, err)
+	}
+	return buf.String(), code
+}
+
+// rows splits plain output into its lines.
+func rows(out string) []string {
+	out = strings.TrimRight(out, "\n")
+	if out == "" {
+		return nil
+	}
+	return strings.Split(out, "\n")
+}
+
+func TestRun(t *testing.T) {
+	r := newFeatureRepo(t)
+	tests := []struct {
+		name string
+		argv []string
+		// want are substrings of each row, newest first
+		want []string
+		code int
+	}{
+		{
+			name: "whole log",
+			want: []string{"feat: grow b", "feat: add b", "second commit", "initial commit"},
+		},
+		{
+			name: "limited",
+			argv: []string{"-n", "2"},
+			want: []string{"feat: grow b", "feat: add b"},
+		},
+		{
+			name: "skip",
+			argv: []string{"--skip", "1", "-n", "1"},
+			want: []string{"feat: add b"},
+		},
+		{
+			name: "grep",
+			argv: []string{"--grep", "^second"},
+			want: []string{"second commit"},
+		},
+		{
+			name: "reverse",
+			argv: []string{"-n", "2", "--reverse"},
+			want: []string{"feat: add b", "feat: grow b"},
+		},
+		{
+			name: "diffs against the fork point",
+			argv: []string{"-n", "2"},
+			want: []string{"1(~),3(+)", "1(~),2(+)"},
+		},
+		{
+			name: "change limits spend -n on matches only",
+			argv: []string{"-n", "1", "--max-changes", "2"},
+			want: []string{"feat: add b"},
+		},
+		{
+			name: "change limits against parents",
+			argv: []string{"-n", "1", "--min-changes", "2", "--diff-against", "parent"},
+			want: []string{"feat: add b"},
+		},
+		{
+			name: "no matches",
+			argv: []string{"--grep", "nothing like this"},
+			code: ExitNoCommits,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, code := runIn(t, r.dir, tt.argv...)
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d", code, tt.code)
+			}
+			got := rows(out)
+			if len(got) != len(tt.want) {
+				t.Fatalf("%d rows, want %d:\n%s", len(got), len(tt.want), out)
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i], want) {
+					t.Errorf("row %d is %q, want it to contain %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRequireChanges(t *testing.T) {
+	r := newFeatureRepo(t)
+	formats := [][]string{
+		{"--format", "table"},
+		{"--format", "markdown"},
+		{"--format", "json"},
+		{"--format", "jsonl"},
+		{"--format", "csv"},
+		{"--format", "dot"},
+		{"--template", "{{.Hash}}"},
+		{"--cochange"},
+	}
+	for _, format := range formats {
+		for _, tt := range []struct {
+			name    string
+			exclude []string
+			code    int
+		}{
+			{"changes", nil, ExitOK},
+			// feature only ever changes b.txt
+			{"none", []string{"-e", "b.txt"}, ExitError},
+		} {
+			t.Run(strings.Join(format, " ")+" "+tt.name, func(t *testing.T) {
+				argv := append([]string{"--require-changes", "--base", "master"}, format...)
+				pa, err := parseIn(r.dir, append(argv, tt.exclude...)...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var stdout, stderr bytes.Buffer
+				pa.stderr = &stderr
+				code, _ := pa.Run(&stdout)
+				if code != tt.code {
+					t.Errorf("exit code %d, want %d", code, tt.code)
+				}
+				// machine-readable output is left alone on stdout
+				if format[0] != "--cochange" && !slices.Contains(format, "table") && !slices.Contains(format, "markdown") {
+					if strings.Contains(stdout.String(), "changes against base") {
+						t.Errorf("summary written to stdout:\n%s", stdout.String())
+					}
+					if !strings.Contains(stderr.String(), "changes against base") {
+						t.Errorf("summary missing from stderr %q", stderr.String())
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPlainRows(t *testing.T) {
+	r := newTestRepo(t)
+	r.commit("say \"hi\", ok\n\nwith a body\n", map[string]string{"a.txt": "a\n"})
+	out, _ := runIn(t, r.dir, "--full")
+	got := rows(out)
+	// the body goes under the message, with the other fields left empty
+	if len(got) != 2 {
+		t.Fatalf("%d rows, want 2:\n%s", len(got), out)
+	}
+	fields := strings.Split(got[0], "\t")
+	if message := fields[len(fields)-1]; message != "(HEAD)(master) say \"hi\", ok" {
+		t.Errorf("message is %q, want it as it is", message)
+	}
+	if want := strings.Repeat("\t", len(fields)-1) + "with a body"; got[1] != want {
+		t.Errorf("body row is %q, want %q", got[1], want)
+	}
+}
+
+func TestRunJSON(t *testing.T) {
+	r := newFeatureRepo(t)
+	out, code := runIn(t, r.dir, "--format", "json")
+	if code != ExitOK {
+		t.Errorf("exit code %d, want %d", code, ExitOK)
+	}
+	var commits []jsonCommit
+	if err := json.Unmarshal([]byte(out), &commits); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, out)
+	}
+	if len(commits) != 4 {
+		t.Fatalf("%d commits, want 4", len(commits))
+	}
+	newest := commits[0]
+	if newest.Message != "feat: grow b" || newest.FilesChanged != 1 || newest.Insertions != 3 || newest.Deletions != 0 {
+		t.Errorf("newest commit is %+v", newest)
+	}
+	if len(newest.Refs) != 1 || newest.Refs[0] != "feature" {
+		t.Errorf("newest commit has refs %q, want feature", newest.Refs)
+	}
+	// master itself has nothing of its own against the base
+	if commits[2].FilesChanged != 0 {
+		t.Errorf("base commit shows %d files changed", commits[2].FilesChanged)
+	}
+}
+
+func TestByWeekJSON(t *testing.T) {
+	r := newFeatureRepo(t)
+	out, code := runIn(t, r.dir, "--by-week", "--format", "json")
+	if code != ExitOK {
+		t.Errorf("exit code %d, want %d", code, ExitOK)
+	}
+	var weeks []jsonWeek
+	if err := json.Unmarshal([]byte(out), &weeks); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, out)
+	}
+	// every commit is a minute after the last, all in the first week of 2024
+	want := []jsonWeek{{Week: "2024-W01", Commits: 4, FilesChanged: 4, Insertions: 5}}
+	if !reflect.DeepEqual(weeks, want) {
+		t.Errorf("weeks are %+v, want %+v", weeks, want)
+	}
+
+	if _, err := parseIn(r.dir, "--by-week", "--format", "csv"); err == nil {
+		t.Error("--by-week with --format csv was accepted")
+	}
+}
+
+func TestResolveColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    Options
+		want    []string
+		wantErr bool
+	}{
+		{name: "defaults", args: Options{}, want: []string{"hash", "date", "author", "diff", "message"}},
+		{name: "given", args: Options{Columns: "hash, message"}, want: []string{"hash", "message"}},
+		{name: "given with their flags", args: Options{Columns: "hash,size,message", Sizes: true, NameStat: true}, want: []string{"hash", "size", "message"}},
+		{name: "unknown", args: Options{Columns: "hash,colour"}, wantErr: true},
+		{name: "sizes left out", args: Options{Columns: "hash,message", Sizes: true}, wantErr: true},
+		{name: "signature left out", args: Options{Columns: "hash,message", Signatures: true}, wantErr: true},
+		{name: "signoff left out", args: Options{Columns: "hash,message", Trailers: true}, wantErr: true},
+		{name: "name-stat without message", args: Options{Columns: "hash,diff", NameStat: true}, wantErr: true},
+		{name: "group-by-day without message", args: Options{Columns: "hash,date", GroupByDay: true}, wantErr: true},
+		{name: "worktree without message", args: Options{Columns: "hash,diff", Worktree: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveColumns(tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want an error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDotAbbrev(t *testing.T) {
+	r := newFeatureRepo(t)
+	head, err := r.repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		argv []string
+		want int
+	}{
+		{nil, 7},
+		{[]string{"--abbrev", "10"}, 10},
+		{[]string{"--full-hash"}, 40},
+	} {
+		out, _ := runIn(t, r.dir, append([]string{"--format", "dot"}, tt.argv...)...)
+		label := "[label=\"" + head.Hash().String()[:tt.want] + "\\n"
+		if !strings.Contains(out, label) {
+			t.Errorf("%q: no node labelled %s in\n%s", tt.argv, label, out)
+		}
+	}
+}
+
+func TestCheckHead(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(r *testRepo)
+		// wantErr is a substring of the error, or "" for none
+		wantErr   string
+		noCommits bool
+	}{
+		{
+			name:  "branch",
+			setup: func(r *testRepo) { r.commit("initial commit", nil) },
+		},
+		{
+			name: "detached",
+			setup: func(r *testRepo) {
+				hash := r.commit("initial commit", nil)
+				r.setRef(plumbing.NewHashReference(plumbing.HEAD, hash))
+			},
+		},
+		{
+			name:      "unborn",
+			setup:     func(r *testRepo) {},
+			wantErr:   "HEAD points to unborn branch master",
+			noCommits: true,
+		},
+		{
+			name: "dangling",
+			setup: func(r *testRepo) {
+				r.commit("initial commit", nil)
+				r.setRef(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("gone")))
+			},
+			wantErr: "HEAD points to branch gone, which does not exist",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRepo(t)
+			tt.setup(r)
+			err := checkHead(r.repo)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error %v, want one containing %q", err, tt.wantErr)
+			}
+			if got := errors.Is(err, ErrNoCommits); got != tt.noCommits {
+				t.Errorf("errors.Is(err, ErrNoCommits) = %v, want %v", got, tt.noCommits)
+			}
+		})
+	}
+}
+
+func TestAnnotatedTagBase(t *testing.T) {
+	r := newFeatureRepo(t)
+	master, err := r.repo.Reference(plumbing.NewBranchReferenceName("master"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagger := &object.Signature{Name: "Alice Smith", Email: "alice@example.com", When: r.when}
+	tag, err := r.repo.CreateTag("v1.0.0", master.Hash(), &git.CreateTagOptions{Tagger: tagger, Message: "release"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a tag of a tag must be peeled twice
+	if _, err := r.repo.CreateTag("v1.0.0-signed", tag.Hash(), &git.CreateTagOptions{Tagger: tagger, Message: "again"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, base := range []string{"v1.0.0", "v1.0.0-signed", tag.Hash().String()} {
+		t.Run(base, func(t *testing.T) {
+			hash, err := resolveRevision(r.repo, base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			commit, err := peelToCommit(r.repo, hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if commit.Hash != master.Hash() {
+				t.Errorf("peeled to %s, want %s", commit.Hash, master.Hash())
+			}
+			out, _ := runIn(t, r.dir, "--base", base, "-n", "2")
+			got := rows(out)
+			if len(got) != 2 || !strings.Contains(got[0], "1(~),3(+)") {
+				t.Errorf("log against %s is\n%s", base, out)
+			}
+		})
+	}
+}
+
+func TestMergeBase(t *testing.T) {
+	r := newTestRepo(t)
+	r.commit("initial commit", map[string]string{"a.txt": "a\n"})
+	fork := r.commit("second commit", map[string]string{"a.txt": "a\nb\n"})
+	r.branch("feature")
+	r.commit("feat: add b", map[string]string{"b.txt": "one\n"})
+	r.commit("feat: grow b", map[string]string{"b.txt": "one\ntwo\n"})
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("master")}); err != nil {
+		t.Fatal(err)
+	}
+	r.commit("master moves on", map[string]string{"c.txt": "c\n"})
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature")}); err != nil {
+		t.Fatal(err)
+	}
+
+	pa, err := parseIn(r.dir, "--status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pa.mergeBase == nil || pa.mergeBase.Hash != fork {
+		t.Fatalf("merge base is %v, want %s", pa.mergeBase, fork)
+	}
+	refHashToName, err := makeHashToNameMap(r.repo, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		mergeBase *object.Commit
+		// the base is named by its branch and then its short hash
+		prefix, suffix string
+	}{
+		{name: "parsed", mergeBase: pa.mergeBase, prefix: "HEAD is 2 ahead, 1 behind master", suffix: ")\n"},
+		// printStatus uses the merge base found by Parse rather than
+		// looking for it again, so one that's taken away is missed
+		{name: "taken away", mergeBase: nil, prefix: "HEAD is 4 ahead, 3 behind master", suffix: ", sharing no history\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pa.mergeBase = tt.mergeBase
+			var buf bytes.Buffer
+			if err := printStatus(&buf, pa, refHashToName); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); !strings.HasPrefix(got, tt.prefix) || !strings.HasSuffix(got, tt.suffix) {
+				t.Errorf("printStatus wrote %q, want %q...%q", got, tt.prefix, tt.suffix)
+			}
+		})
+	}
+}
+
+func TestNestedDirectory(t *testing.T) {
+	r := newFeatureRepo(t)
+	r.commit("feat: add nested", map[string]string{"sub/deeper/c.txt": "c\n"})
+	top, _ := runIn(t, r.dir)
+	nested, code := runIn(t, filepath.Join(r.dir, "sub", "deeper"))
+	if code != ExitOK {
+		t.Errorf("exit code %d, want %d", code, ExitOK)
+	}
+	if got := rows(nested); len(got) != 5 || !strings.Contains(got[0], "feat: add nested") {
+		t.Errorf("log from a nested directory is\n%s", nested)
+	}
+	if nested != top {
+		t.Errorf("log from a nested directory differs from the top:\n%s\nwant\n%s", nested, top)
+	}
+}
+
+// gitCommand runs git in dir, skipping the test when git isn't installed,
+// for what go-git can't do, like adding worktrees.
+func gitCommand(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestPrintDivergence(t *testing.T) {
+	noColor := color.NoColor
+	t.Cleanup(func() { color.NoColor = noColor })
+	color.NoColor = true
+	r := newTestRepo(t)
+	base := r.commit("base", map[string]string{"a.txt": "a\n"})
+	local := r.commit("local", map[string]string{"a.txt": "local\n"})
+	remote := r.commitMerge("remote", []plumbing.Hash{base}, map[string]string{"a.txt": "remote\n"})
+	tests := []struct {
+		name          string
+		local, remote plumbing.Hash
+		want          string
+	}{
+		{"ahead", local, base, "ahead: master is ahead of origin/master: 1(+) local only"},
+		{"behind", base, remote, "behind: master is behind origin/master: 1(-) remote only"},
+		{"diverged", local, remote, "diverged: master differs from origin/master: 1(+) local only, 1(-) remote only"},
+		{"in step", local, local, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r.setRef(plumbing.NewHashReference(plumbing.NewBranchReferenceName("master"), tt.local))
+			r.setRef(plumbing.NewHashReference(plumbing.NewRemoteReferenceName("origin", "master"), tt.remote))
+			var buf bytes.Buffer
+			if err := printDivergence(&buf, &Log{repo: r.repo}); err != nil {
+				t.Fatal(err)
+			}
+			got, _, _ := strings.Cut(buf.String(), "\n")
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenRepo(t *testing.T) {
+	r := newFeatureRepo(t)
+	if err := os.MkdirAll(filepath.Join(r.dir, "sub", "deeper"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	linked := filepath.Join(t.TempDir(), "linked")
+	gitCommand(t, r.dir, "worktree", "add", "-q", "-b", "other", linked, "master")
+
+	tests := []struct {
+		name    string
+		path    string
+		env     map[string]string
+		head    plumbing.ReferenceName
+		wantTop string
+	}{
+		{name: "top", path: r.dir, head: "refs/heads/feature", wantTop: r.dir},
+		{name: "subdirectory", path: filepath.Join(r.dir, "sub", "deeper"), head: "refs/heads/feature", wantTop: r.dir},
+		{name: "linked worktree", path: linked, head: "refs/heads/other", wantTop: linked},
+		{
+			name:    "GIT_DIR",
+			path:    t.TempDir(),
+			env:     map[string]string{"GIT_DIR": filepath.Join(r.dir, ".git"), "GIT_WORK_TREE": r.dir},
+			head:    "refs/heads/feature",
+			wantTop: r.dir,
+		},
+		{
+			// the linked worktree's git dir has its own HEAD but the
+			// branches of the main one, found through commondir
+			name:    "GIT_DIR of a linked worktree",
+			path:    t.TempDir(),
+			env:     map[string]string{"GIT_DIR": filepath.Join(r.dir, ".git", "worktrees", "linked"), "GIT_WORK_TREE": linked},
+			head:    "refs/heads/other",
+			wantTop: linked,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			repo, err := OpenRepository(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			head, err := repo.Head()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if head.Name() != tt.head {
+				t.Errorf("HEAD is %s, want %s", head.Name(), tt.head)
+			}
+			// the refs of the main worktree are there from all of them
+			if _, err := repo.Reference(plumbing.NewBranchReferenceName("feature"), true); err != nil {
+				t.Errorf("feature branch not found: %v", err)
+			}
+			wt, err := repo.Worktree()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := wt.Filesystem.Root(); got != tt.wantTop {
+				t.Errorf("worktree is at %s, want %s", got, tt.wantTop)
+			}
+			if _, code := runIn(t, tt.path, "-n", "1"); code != ExitOK {
+				t.Errorf("exit code %d, want %d", code, ExitOK)
+			}
+		})
+	}
+}
+
+func TestBareClone(t *testing.T) {
+	r := newFeatureRepo(t)
+	bare := filepath.Join(t.TempDir(), "bare.git")
+	gitCommand(t, r.dir, "clone", "-q", "--bare", r.dir, bare)
+
+	repo, err := OpenRepository(bare)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Worktree(); !errors.Is(err, git.ErrIsBareRepository) {
+		t.Errorf("opened as a repository with a worktree: %v", err)
+	}
+	out, code := runIn(t, bare, "-n", "2")
+	if code != ExitOK {
+		t.Errorf("exit code %d, want %d", code, ExitOK)
+	}
+	got := rows(out)
+	if len(got) != 2 || !strings.Contains(got[0], "feat: grow b") || !strings.Contains(got[0], "1(~),3(+)") {
+		t.Errorf("log of a bare clone is\n%s", out)
+	}
+}
+
+func TestDiffTexts(t *testing.T) {
+	noColor := color.NoColor
+	t.Cleanup(func() { color.NoColor = noColor })
+	color.NoColor = true
+
+	tests := []struct {
+		name     string
+		stat     diffStat
+		text     string
+		markdown string
+		net      string
+	}{
+		{
+			name:     "empty",
+			stat:     diffStat{},
+			text:     "0",
+			markdown: "0",
+			net:      "0",
+		},
+		{
+			name:     "mode only",
+			stat:     diffStat{files: 1},
+			text:     "1(~)",
+			markdown: "1 file, +0 -0",
+			net:      "0",
+		},
+		{
+			name:     "binary only",
+			stat:     diffStat{files: 1, binaries: 1},
+			text:     "1(~),1 bin",
+			markdown: "1 file, +0 -0, 1 bin",
+			net:      "1 bin",
+		},
+		{
+			name:     "lines only",
+			stat:     diffStat{files: 2, insertions: 5, deletions: 2},
+			text:     "2(~),5(+),2(-)",
+			markdown: "2 files, +5 -2",
+			net:      "+3",
+		},
+		{
+			name:     "mixed",
+			stat:     diffStat{files: 3, insertions: 1, deletions: 2, binaries: 2},
+			text:     "3(~),1(+),2(-),2 bin",
+			markdown: "3 files, +1 -2, 2 bin",
+			net:      "-1",
+		},
+		{
+			// as in the per-file rows of --name-stat
+			name:     "file without a count",
+			stat:     diffStat{insertions: 4},
+			text:     "4(+)",
+			markdown: "+4 -0",
+			net:      "+4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatDiffStat(tt.stat); got != tt.text {
+				t.Errorf("formatDiffStat = %q, want %q", got, tt.text)
+			}
+			if got := diffText(tt.stat, &Log{format: "table", diffStyle: "text"}); got != tt.text {
+				t.Errorf("diffText = %q, want %q", got, tt.text)
+			}
+			if got := diffText(tt.stat, &Log{format: "markdown", diffStyle: "text"}); got != tt.markdown {
+				t.Errorf("diffText for markdown = %q, want %q", got, tt.markdown)
+			}
+			if got := netText(tt.stat); got != tt.net {
+				t.Errorf("netText = %q, want %q", got, tt.net)
+			}
+			// bars need color, so without it the counts are shown
+			if got := prettyDiff(tt.stat, 10, &Log{format: "table", diffStyle: "bar"}); got != tt.text {
+				t.Errorf("prettyDiff with bars and no color = %q, want %q", got, tt.text)
+			}
+		})
+	}
+
+	t.Run("bars", func(t *testing.T) {
+		color.NoColor = false
+		defer func() { color.NoColor = true }()
+		pa := &Log{format: "table", diffStyle: "bar"}
+		for _, stat := range []diffStat{{}, {files: 1}, {files: 1, binaries: 1}} {
+			// with no lines to draw the counts are shown instead
+			if got, want := prettyDiff(stat, 10, pa), formatDiffStat(stat); got != want {
+				t.Errorf("prettyDiff(%+v) = %q, want %q", stat, got, want)
+			}
+		}
+		got := text.StripEscape(prettyDiff(diffStat{files: 1, insertions: 5, deletions: 5}, 10, pa))
+		if want := strings.Repeat("█", diffBarWidth); got != want {
+			t.Errorf("bar for the largest change is %q, want %q", got, want)
+		}
+	})
+}
+
+func TestHumanRelativeTime(t *testing.T) {
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		labels string
+		when   time.Time
+		want   string
+	}{
+		{"today", "", time.Date(2024, 1, 10, 0, 30, 0, 0, time.UTC), "today"},
+		{"yesterday", "", time.Date(2024, 1, 9, 23, 0, 0, 0, time.UTC), "yesterday"},
+		{"this week", "", time.Date(2024, 1, 5, 9, 0, 0, 0, time.UTC), "Friday"},
+		{"older", "", time.Date(2023, 12, 1, 9, 0, 0, 0, time.UTC), "2023-12-01"},
+		{"overridden", "this-week=www,older=mmm d", time.Date(2023, 12, 1, 9, 0, 0, 0, time.UTC), "Dec 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels, err := parseHumanDateLabels(tt.labels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := humanRelativeTime(tt.when, now, labels); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Go reference-time layouts are no more valid here than for --date
+	if _, err := parseHumanDateLabels("older=Jan 2"); err == nil {
+		t.Error("older=Jan 2 was accepted")
+	}
+}
+
+func TestFirstParentOverForkPoint(t *testing.T) {
+	r := newTestRepo(t)
+	r.commit("initial commit", map[string]string{"a.txt": "a\n"})
+	r.commit("second commit", map[string]string{"a.txt": "a\nb\n"})
+	r.branch("feature")
+	c := r.commit("feat: add c", map[string]string{"c.txt": "c\n"})
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("master")}); err != nil {
+		t.Fatal(err)
+	}
+	d := r.commit("master moves on", map[string]string{"d.txt": "d\n"})
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature")}); err != nil {
+		t.Fatal(err)
+	}
+	// merging master in makes it the fork point, which the first-parent
+	// walk steps over
+	r.commitMerge("Merge master into feature", []plumbing.Hash{c, d}, map[string]string{"d.txt": "d\n"})
+
+	out, _ := runIn(t, r.dir, "--first-parent")
+	got := rows(out)
+	want := []struct {
+		message string
+		diff    bool
+	}{
+		{"Merge master into feature", true},
+		{"feat: add c", true},
+		{"second commit", false},
+		{"initial commit", false},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%d rows, want %d:\n%s", len(got), len(want), out)
+	}
+	for i, w := range want {
+		fields := strings.Split(got[i], "\t")
+		if !strings.Contains(got[i], w.message) || len(fields) < 4 || (fields[3] != "") != w.diff {
+			t.Errorf("row %d is %q, want %q with a diff %v", i, got[i], w.message, w.diff)
+		}
+	}
+}
+
+func TestRunJSONL(t *testing.T) {
+	r := newFeatureRepo(t)
+	for _, argv := range [][]string{nil, {"--diff-against", "parent"}, {"--no-diff"}} {
+		t.Run(strings.Join(argv, " "), func(t *testing.T) {
+			out, _ := runIn(t, r.dir, append([]string{"--format", "json"}, argv...)...)
+			var want []jsonCommit
+			if err := json.Unmarshal([]byte(out), &want); err != nil {
+				t.Fatal(err)
+			}
+			out, code := runIn(t, r.dir, append([]string{"--format", "jsonl"}, argv...)...)
+			if code != ExitOK {
+				t.Errorf("exit code %d, want %d", code, ExitOK)
+			}
+			lines := rows(out)
+			if len(lines) != len(want) {
+				t.Fatalf("%d lines, want %d:\n%s", len(lines), len(want), out)
+			}
+			// each line is one of the objects of the json array
+			for i, line := range lines {
+				var got jsonCommit
+				if err := json.Unmarshal([]byte(line), &got); err != nil {
+					t.Fatalf("line %d is invalid json: %v", i, err)
+				}
+				if !got.When.Equal(want[i].When) {
+					t.Errorf("line %d is at %s, want %s", i, got.When, want[i].When)
+				}
+				got.When = want[i].When
+				if !reflect.DeepEqual(got, want[i]) {
+					t.Errorf("line %d is %+v, want %+v", i, got, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCompilePathspec(t *testing.T) {
+	tests := []struct {
+		spec, prefix string
+		match        []string
+		noMatch      []string
+	}{
+		{spec: "generated", match: []string{"generated", "generated/a.go"}, noMatch: []string{"sub/generated", "generated.go"}},
+		{spec: "generated", prefix: "sub", match: []string{"sub/generated/a.go"}, noMatch: []string{"generated/a.go"}},
+		{spec: ":/generated", prefix: "sub", match: []string{"generated/a.go"}, noMatch: []string{"sub/generated/a.go"}},
+		{spec: ":(top)generated", prefix: "sub", match: []string{"generated/a.go"}, noMatch: []string{"sub/generated/a.go"}},
+		{spec: "../docs", prefix: "sub", match: []string{"docs/a.md"}, noMatch: []string{"sub/docs/a.md"}},
+		{spec: ".", prefix: "sub", match: []string{"sub/a.go"}, noMatch: []string{"a.go", "subway/a.go"}},
+		{spec: "*.go", prefix: "sub", match: []string{"sub/a.go", "sub/x/b.go"}, noMatch: []string{"a.go"}},
+		// the directory run from isn't a pattern
+		{spec: "a.go", prefix: "s*b", match: []string{"s*b/a.go"}, noMatch: []string{"sub/a.go"}},
+		{spec: "file[0-9].txt", match: []string{"file1.txt"}, noMatch: []string{"filex.txt"}},
+		{spec: "file[!0-9].txt", match: []string{"filex.txt"}, noMatch: []string{"file1.txt"}},
+		// class contents are characters, not regular expression syntax
+		{spec: `file[.\w].txt`, match: []string{"file..txt", `file\.txt`, "filew.txt"}, noMatch: []string{"filex.txt", "file1.txt"}},
+		{spec: "file[.txt", match: []string{"file[.txt"}, noMatch: []string{"file..txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix+":"+tt.spec, func(t *testing.T) {
+			re, err := compilePathspec(tt.spec, tt.prefix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.match {
+				if !re.MatchString(name) {
+					t.Errorf("%s doesn't match %s", re, name)
+				}
+			}
+			for _, name := range tt.noMatch {
+				if re.MatchString(name) {
+					t.Errorf("%s matches %s", re, name)
+				}
+			}
+		})
+	}
+	if _, err := compilePathspec(":(glob)*.go", ""); err == nil {
+		t.Error("unsupported pathspec magic was accepted")
+	}
+}
+
+func TestExcludeFromSubdirectory(t *testing.T) {
+	r := newTestRepo(t)
+	r.commit("initial commit", map[string]string{"a.txt": "a\n"})
+	r.branch("feature")
+	r.commit("feat: generate", map[string]string{
+		"generated/top.txt":     "1\n",
+		"sub/generated/sub.txt": "1\n2\n",
+		"sub/code.txt":          "1\n2\n3\n",
+	})
+	tests := []struct {
+		dir, exclude, want string
+	}{
+		{dir: r.dir, exclude: "generated", want: "2(~),5(+)"},
+		{dir: filepath.Join(r.dir, "sub"), exclude: "generated", want: "2(~),4(+)"},
+		{dir: filepath.Join(r.dir, "sub"), exclude: ":/generated", want: "2(~),5(+)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dir+" "+tt.exclude, func(t *testing.T) {
+			out, _ := runIn(t, tt.dir, "-n", "1", "-e", tt.exclude)
+			if !strings.Contains(out, "\t"+tt.want+"\t") {
+				t.Errorf("log is %q, want a diff of %s", out, tt.want)
+			}
+		})
+	}
+}
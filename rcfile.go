@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pmwals09/git-pretty-log/prettylog"
+)
+
+const rcFileName = ".gitprettylogrc"
+
+const rcPrecedence = "flags on the command line override the repository's " + rcFileName + ", which overrides ~/" + rcFileName
+
+// rcKeys are the keys an rc file may set, with the flags each one seeds.
+var rcKeys = map[string][]string{
+	"base":        {"base", "b"},
+	"num-commits": {"num-commits", "n", "max-count"},
+	"exclude":     {"exclude", "e"},
+	"color":       {"color"},
+	"date":        {"date"},
+}
+
+// rcSetting is one key = value line of an rc file.
+type rcSetting struct {
+	line  int
+	key   string
+	value string
+}
+
+// applyRCFiles seeds the flags of fs that weren't given on the command line
+// from the .gitprettylogrc at the root of the repository at repoPath and then
+// the one in the home directory. Each key is taken from the first of these
+// that sets it, so an exclude list in the repository replaces the home one
+// rather than adding to it. Missing files are ignored.
+func applyRCFiles(fs *flag.FlagSet, repoPath string) error {
+	seeded := make(map[string]bool)
+	for key, names := range rcKeys {
+		fs.Visit(func(f *flag.Flag) {
+			if slices.Contains(names, f.Name) {
+				seeded[key] = true
+			}
+		})
+	}
+
+	// the repository's file sits at the top of its worktree, wherever in it
+	// the tool is run from
+	root := repoPath
+	if repo, err := prettylog.OpenRepository(repoPath); err == nil {
+		if wt, err := repo.Worktree(); err == nil {
+			root = wt.Filesystem.Root()
+		}
+	}
+	paths := []string{filepath.Join(root, rcFileName)}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, rcFileName))
+	}
+	for _, path := range paths {
+		settings, err := readRCFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		fileKeys := make(map[string]bool)
+		for _, s := range settings {
+			if seeded[s.key] {
+				continue
+			}
+			if err := fs.Set(rcKeys[s.key][0], s.value); err != nil {
+				return fmt.Errorf("%s:%d: invalid %s %q: %v; %s", path, s.line, s.key, s.value, err, rcPrecedence)
+			}
+			fileKeys[s.key] = true
+		}
+		for key := range fileKeys {
+			seeded[key] = true
+		}
+	}
+	return nil
+}
+
+// readRCFile parses an rc file of key = value lines. Blank lines and lines
+// starting with # are skipped, and values may be double-quoted. A key may be
+// repeated to give a list, as with exclude.
+func readRCFile(path string) ([]rcSetting, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var settings []rcSetting
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value, got %q; %s", path, line, text, rcPrecedence)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if _, ok := rcKeys[key]; !ok {
+			return nil, fmt.Errorf("%s:%d: unknown key %q, expected one of base, num-commits, exclude, color or date; %s", path, line, key, rcPrecedence)
+		}
+		if strings.HasPrefix(value, `"`) {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: malformed quoted value for %s: %s; %s", path, line, key, value, rcPrecedence)
+			}
+			value = unquoted
+		}
+		settings = append(settings, rcSetting{line: line, key: key, value: value})
+	}
+	return settings, scanner.Err()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+)
+
+// buildVersion, buildCommit and buildDate describe the build. Release builds
+// set them with -ldflags, e.g.
+//
+//	go build -ldflags "-X main.buildVersion=v1.2.0 -X main.buildCommit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Anything left unset is filled in from the build info Go embeds, which knows
+// the module version when installed with go install and the commit when
+// built from a checkout.
+var (
+	buildVersion = ""
+	buildCommit  = ""
+	buildDate    = ""
+)
+
+// errVersion is returned, like flag.ErrHelp, when --version is given.
+var errVersion = errors.New("version requested")
+
+// printVersion prints the version, commit and build date of the binary,
+// with unknown for whatever can't be found out.
+func printVersion(out io.Writer) {
+	v, c, d := buildVersion, buildCommit, buildDate
+	dirty := false
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && c == "":
+				c = setting.Value
+			case setting.Key == "vcs.time" && d == "":
+				d = setting.Value
+			case setting.Key == "vcs.modified" && buildCommit == "":
+				dirty = setting.Value == "true"
+			}
+		}
+	}
+	if dirty && c != "" {
+		// the build had changes on top of the commit
+		c += "-dirty"
+	}
+	for _, field := range []*string{&v, &c, &d} {
+		if *field == "" {
+			*field = "unknown"
+		}
+	}
+	fmt.Fprintf(out, "git-pretty-log %s (commit %s, built %s)\n", v, c, d)
+}